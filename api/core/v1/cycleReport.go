@@ -0,0 +1,77 @@
+package core
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type CycleReport struct {
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt,omitempty"`
+	DryRun     bool            `json:"dryRun,omitempty"`
+	Actions    []CleanupAction `json:"actions"`
+}
+
+type CleanupAction struct {
+	Time          time.Time     `json:"time"`
+	Kind          string        `json:"kind"`
+	Namespace     string        `json:"namespace,omitempty"`
+	Name          string        `json:"name,omitempty"`
+	Pipeline      string        `json:"pipeline,omitempty"`
+	ID            string        `json:"id,omitempty"`
+	Status        string        `json:"status,omitempty"`
+	Rule          string        `json:"rule"`
+	AgeReference  string        `json:"ageReference"`
+	ReferenceTime time.Time     `json:"referenceTime"`
+	Age           time.Duration `json:"age"`
+	MaxAge        time.Duration `json:"maxAge"`
+	Action        string        `json:"action"`
+	Result        string        `json:"result"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// MarshalJSON writes Age and MaxAge as duration strings like 1h30m0s, rather than as nanoseconds
+func (a CleanupAction) MarshalJSON() ([]byte, error) {
+	type cleanupAction CleanupAction
+	return json.Marshal(struct {
+		cleanupAction
+		Age    string `json:"age"`
+		MaxAge string `json:"maxAge"`
+	}{
+		cleanupAction: cleanupAction(a),
+		Age:           a.Age.String(),
+		MaxAge:        a.MaxAge.String(),
+	})
+}
+
+// UnmarshalJSON reads Age and MaxAge from the duration strings written by MarshalJSON
+func (a *CleanupAction) UnmarshalJSON(data []byte) (err error) {
+	type cleanupAction CleanupAction
+	aux := struct {
+		*cleanupAction
+		Age    string `json:"age"`
+		MaxAge string `json:"maxAge"`
+	}{
+		cleanupAction: (*cleanupAction)(a),
+	}
+
+	err = json.Unmarshal(data, &aux)
+	if err != nil {
+		return
+	}
+
+	if aux.Age != "" {
+		a.Age, err = time.ParseDuration(aux.Age)
+		if err != nil {
+			return
+		}
+	}
+	if aux.MaxAge != "" {
+		a.MaxAge, err = time.ParseDuration(aux.MaxAge)
+		if err != nil {
+			return
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanupActionJSON(t *testing.T) {
+	t.Run("MarshalsAgesAsDurationStrings", func(t *testing.T) {
+
+		action := CleanupAction{Kind: "build", ID: "1", Age: 90 * time.Minute, MaxAge: time.Hour}
+
+		// act
+		bytes, err := json.Marshal(action)
+
+		assert.Nil(t, err)
+		var fields map[string]interface{}
+		assert.Nil(t, json.Unmarshal(bytes, &fields))
+		assert.Equal(t, "1h30m0s", fields["age"])
+		assert.Equal(t, "1h0m0s", fields["maxAge"])
+		assert.Equal(t, "build", fields["kind"])
+		assert.Equal(t, "1", fields["id"])
+	})
+
+	t.Run("UnmarshalsWhatItMarshals", func(t *testing.T) {
+
+		action := CleanupAction{Time: time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC), Kind: "build", ID: "1", Age: 90 * time.Minute, MaxAge: time.Hour, Action: "cancel", Result: "succeeded"}
+		bytes, err := json.Marshal(action)
+		assert.Nil(t, err)
+
+		// act
+		var unmarshalled CleanupAction
+		err = json.Unmarshal(bytes, &unmarshalled)
+
+		assert.Nil(t, err)
+		assert.Equal(t, action, unmarshalled)
+	})
+
+	t.Run("ReturnsErrorForInvalidDuration", func(t *testing.T) {
+
+		var action CleanupAction
+
+		// act
+		err := json.Unmarshal([]byte(`{"age": "forever"}`), &action)
+
+		assert.NotNil(t, err)
+	})
+}
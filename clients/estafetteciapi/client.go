@@ -1,38 +1,78 @@
 package estafetteciapi
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	contracts "github.com/estafette/estafette-ci-contracts"
 	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/internal/tracing"
 	foundation "github.com/estafette/estafette-foundation"
-	"github.com/opentracing-contrib/go-stdlib/nethttp"
-	"github.com/opentracing/opentracing-go"
 	"github.com/rs/zerolog/log"
-	"github.com/sethgrid/pester"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/time/rate"
 )
 
+var tracer = otel.Tracer("github.com/estafette/estafette-ci-hanging-job-cleaner/clients/estafetteciapi")
+
 type Client interface {
 	GetToken(ctx context.Context) (token string, err error)
-	GetRunningBuilds(ctx context.Context, pageNumber, pageSize int) (pagedBuildResponse corev1.PagedBuildResponse, err error)
-	GetRunningReleases(ctx context.Context, pageNumber, pageSize int) (pagedReleasesResponse corev1.PagedReleasesResponse, err error)
+	GetBuilds(ctx context.Context, statuses []string, pageNumber, pageSize int) (pagedBuildResponse corev1.PagedBuildResponse, err error)
+	GetReleases(ctx context.Context, statuses []string, pageNumber, pageSize int) (pagedReleasesResponse corev1.PagedReleasesResponse, err error)
 	CancelBuild(ctx context.Context, build *contracts.Build) (err error)
 	CancelRelease(ctx context.Context, release *contracts.Release) (err error)
+	MarkBuildFailed(ctx context.Context, build *contracts.Build) (err error)
+	SetCredentials(clientID, clientSecret string)
 }
 
-// NewClient returns a new estafetteciapi.Client
-func NewClient(apiBaseURL, clientID, clientSecret string) (Client, error) {
+// NewClient returns a new estafetteciapi.Client, reusing a single http client with keep-alive connections for all
+// requests; each attempt times out after timeout and failed idempotent requests get attempted up to maxRetries times
+// with the backoff strategy exponential, exponential-jitter, linear or linear-jitter, as long as all attempts together
+// stay within maxDuration; all attempts together are limited to qps requests per second with bursts of up to burst
+func NewClient(apiBaseURL, clientID, clientSecret string, timeout time.Duration, maxRetries int, backoff string, maxDuration time.Duration, qps float64, burst int) (Client, error) {
+
+	backoffStrategy, ok := backoffStrategies[backoff]
+	if !ok {
+		return nil, fmt.Errorf("backoff strategy %v is not supported", backoff)
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
 	return &client{
 		apiBaseURL:   apiBaseURL,
 		clientID:     clientID,
 		clientSecret: clientSecret,
+		httpClient: &http.Client{
+			Transport: otelhttp.NewTransport(transport),
+			Timeout:   timeout,
+		},
+		maxRetries:  maxRetries,
+		backoff:     backoffStrategy,
+		maxDuration: maxDuration,
+		limiter:     newRateLimiter(qps, burst),
 	}, nil
 }
 
@@ -41,18 +81,29 @@ type client struct {
 	clientID     string
 	clientSecret string
 	token        string
+	httpClient   *http.Client
+	maxRetries   int
+	backoff      backoffStrategy
+	maxDuration  time.Duration
+	limiter      *rate.Limiter
+
+	// credentialsMutex guards clientID and clientSecret, which get replaced when their mounted secret gets rotated, and
+	// token, which gets refreshed between cycles while cycles triggered through the admin api can be using it
+	credentialsMutex sync.RWMutex
 }
 
 func (c *client) GetToken(ctx context.Context) (token string, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "estafetteciapi.Client:GetToken")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "estafetteciapi.Client:GetToken")
+	defer func() { tracing.End(span, err) }()
 
 	log.Debug().Msgf("Retrieving JWT token")
 
+	c.credentialsMutex.RLock()
 	clientObject := contracts.Client{
 		ClientID:     c.clientID,
 		ClientSecret: c.clientSecret,
 	}
+	c.credentialsMutex.RUnlock()
 
 	bytes, err := json.Marshal(clientObject)
 	if err != nil {
@@ -64,7 +115,11 @@ func (c *client) GetToken(ctx context.Context) (token string, err error) {
 		"Content-Type": "application/json",
 	}
 
-	responseBody, err := c.postRequest(getTokenURL, span, strings.NewReader(string(bytes)), headers)
+	responseBody, err := c.postRequest(ctx, getTokenURL, strings.NewReader(string(bytes)), headers)
+	if err != nil {
+		log.Error().Err(err).Str("url", getTokenURL).Msgf("Failed retrieving token")
+		return
+	}
 
 	tokenResponse := struct {
 		Token string `json:"token"`
@@ -78,26 +133,40 @@ func (c *client) GetToken(ctx context.Context) (token string, err error) {
 	}
 
 	// set token
+	c.credentialsMutex.Lock()
 	c.token = tokenResponse.Token
+	c.credentialsMutex.Unlock()
 
 	return tokenResponse.Token, nil
 }
 
-func (c *client) GetRunningBuilds(ctx context.Context, pageNumber, pageSize int) (pagedBuildResponse corev1.PagedBuildResponse, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "estafetteciapi.Client:GetRunningBuilds")
-	defer span.Finish()
+// getToken returns the token retrieved by the last call to GetToken
+func (c *client) getToken() string {
+	c.credentialsMutex.RLock()
+	defer c.credentialsMutex.RUnlock()
 
-	log.Info().Msgf("Retrieving pending/running/canceling builds page %v of size %v...", pageNumber, pageSize)
+	return c.token
+}
+
+func (c *client) GetBuilds(ctx context.Context, statuses []string, pageNumber, pageSize int) (pagedBuildResponse corev1.PagedBuildResponse, err error) {
+	ctx, span := tracer.Start(ctx, "estafetteciapi.Client:GetBuilds")
+	defer func() { tracing.End(span, err) }()
+
+	log.Info().Msgf("Retrieving %v builds page %v of size %v...", strings.Join(statuses, "/"), pageNumber, pageSize)
 
-	span.LogKV("page[number]", pageNumber, "page[size]", pageSize)
+	span.SetAttributes(
+		attribute.StringSlice("filter.status", statuses),
+		attribute.Int("page.number", pageNumber),
+		attribute.Int("page.size", pageSize),
+	)
 
-	getBuildsURL := fmt.Sprintf("%v/api/builds?filter[status]=running&filter[status]=pending&filter[status]=canceling&page[number]=%v&page[size]=%v", c.apiBaseURL, pageNumber, pageSize)
+	getBuildsURL := fmt.Sprintf("%v/api/builds?%vpage[number]=%v&page[size]=%v", c.apiBaseURL, statusFilterQuery(statuses), pageNumber, pageSize)
 	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", c.token),
+		"Authorization": fmt.Sprintf("Bearer %v", c.getToken()),
 		"Content-Type":  "application/json",
 	}
 
-	responseBody, err := c.getRequest(getBuildsURL, span, nil, headers)
+	responseBody, err := c.getRequest(ctx, getBuildsURL, nil, headers)
 	if err != nil {
 		log.Error().Err(err).Str("url", getBuildsURL).Msgf("Failed retrieving builds response")
 		return
@@ -110,26 +179,30 @@ func (c *client) GetRunningBuilds(ctx context.Context, pageNumber, pageSize int)
 		return
 	}
 
-	log.Info().Msgf("Retrieved %v pending/running/canceling builds for page %v of size %v of %v total pages", len(pagedBuildResponse.Items), pageNumber, pageSize, pagedBuildResponse.Pagination.TotalPages)
+	log.Info().Msgf("Retrieved %v %v builds for page %v of size %v of %v total pages", len(pagedBuildResponse.Items), strings.Join(statuses, "/"), pageNumber, pageSize, pagedBuildResponse.Pagination.TotalPages)
 
 	return
 }
 
-func (c *client) GetRunningReleases(ctx context.Context, pageNumber, pageSize int) (pagedReleasesResponse corev1.PagedReleasesResponse, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "estafetteciapi.Client:GetRunningReleases")
-	defer span.Finish()
+func (c *client) GetReleases(ctx context.Context, statuses []string, pageNumber, pageSize int) (pagedReleasesResponse corev1.PagedReleasesResponse, err error) {
+	ctx, span := tracer.Start(ctx, "estafetteciapi.Client:GetReleases")
+	defer func() { tracing.End(span, err) }()
 
-	log.Info().Msgf("Retrieving pending/running/canceling releases page %v of size %v...", pageNumber, pageSize)
+	log.Info().Msgf("Retrieving %v releases page %v of size %v...", strings.Join(statuses, "/"), pageNumber, pageSize)
 
-	span.LogKV("page[number]", pageNumber, "page[size]", pageSize)
+	span.SetAttributes(
+		attribute.StringSlice("filter.status", statuses),
+		attribute.Int("page.number", pageNumber),
+		attribute.Int("page.size", pageSize),
+	)
 
-	getReleasesURL := fmt.Sprintf("%v/api/releases?filter[status]=running&filter[status]=pending&filter[status]=canceling&page[number]=%v&page[size]=%v", c.apiBaseURL, pageNumber, pageSize)
+	getReleasesURL := fmt.Sprintf("%v/api/releases?%vpage[number]=%v&page[size]=%v", c.apiBaseURL, statusFilterQuery(statuses), pageNumber, pageSize)
 	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", c.token),
+		"Authorization": fmt.Sprintf("Bearer %v", c.getToken()),
 		"Content-Type":  "application/json",
 	}
 
-	responseBody, err := c.getRequest(getReleasesURL, span, nil, headers)
+	responseBody, err := c.getRequest(ctx, getReleasesURL, nil, headers)
 	if err != nil {
 		log.Error().Err(err).Str("url", getReleasesURL).Msgf("Failed retrieving releases response")
 		return
@@ -142,25 +215,34 @@ func (c *client) GetRunningReleases(ctx context.Context, pageNumber, pageSize in
 		return
 	}
 
-	log.Info().Msgf("Retrieved %v pending/running/canceling releases for page %v of size %v of %v total pages", len(pagedReleasesResponse.Items), pageNumber, pageSize, pagedReleasesResponse.Pagination.TotalPages)
+	log.Info().Msgf("Retrieved %v %v releases for page %v of size %v of %v total pages", len(pagedReleasesResponse.Items), strings.Join(statuses, "/"), pageNumber, pageSize, pagedReleasesResponse.Pagination.TotalPages)
 
 	return
 }
 
 func (c *client) CancelBuild(ctx context.Context, build *contracts.Build) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "estafetteciapi.Client:CancelBuild")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "estafetteciapi.Client:CancelBuild")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("pipeline", build.GetFullRepoPath()),
+		attribute.String("build.id", build.ID),
+	)
 
 	log.Info().Msgf("Canceling build for pipeline %v/%v/%v with id %v, status %v and started at %v...", build.RepoSource, build.RepoOwner, build.RepoName, build.ID, build.BuildStatus, build.InsertedAt)
 
 	// DELETE /api/pipelines/:source/:owner/:repo/builds/:revisionOrId
 	cancelBuildURL := fmt.Sprintf("%v/api/pipelines/%v/%v/%v/builds/%v", c.apiBaseURL, build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
 	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", c.token),
+		"Authorization": fmt.Sprintf("Bearer %v", c.getToken()),
 		"Content-Type":  "application/json",
 	}
 
-	responseBody, err := c.deleteRequest(cancelBuildURL, span, nil, headers)
+	responseBody, err := c.deleteRequest(ctx, cancelBuildURL, nil, headers)
+	if HasStatusCode(err, http.StatusNotFound, http.StatusConflict) {
+		// the build is gone or already finished or canceling, which is what canceling it was meant to achieve
+		log.Info().Err(err).Msgf("Build for pipeline %v/%v/%v with id %v is no longer running", build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
+		return nil
+	}
 	if err != nil {
 		log.Error().Err(err).Str("url", cancelBuildURL).Msgf("Failed canceling build for pipeline %v/%v/%v with id %v", build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
 		return
@@ -172,19 +254,28 @@ func (c *client) CancelBuild(ctx context.Context, build *contracts.Build) (err e
 }
 
 func (c *client) CancelRelease(ctx context.Context, release *contracts.Release) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "estafetteciapi.Client:CancelRelease")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "estafetteciapi.Client:CancelRelease")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("pipeline", release.GetFullRepoPath()),
+		attribute.String("release.id", release.ID),
+	)
 
 	log.Info().Msgf("Canceling release for pipeline %v/%v/%v with id %v, status %v and started at %v...", release.RepoSource, release.RepoOwner, release.RepoName, release.ID, release.ReleaseStatus, release.InsertedAt)
 
 	// DELETE /api/pipelines/:source/:owner/:repo/releases/:id
 	cancelReleaseURL := fmt.Sprintf("%v/api/pipelines/%v/%v/%v/releases/%v", c.apiBaseURL, release.RepoSource, release.RepoOwner, release.RepoName, release.ID)
 	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", c.token),
+		"Authorization": fmt.Sprintf("Bearer %v", c.getToken()),
 		"Content-Type":  "application/json",
 	}
 
-	responseBody, err := c.deleteRequest(cancelReleaseURL, span, nil, headers)
+	responseBody, err := c.deleteRequest(ctx, cancelReleaseURL, nil, headers)
+	if HasStatusCode(err, http.StatusNotFound, http.StatusConflict) {
+		// the release is gone or already finished or canceling, which is what canceling it was meant to achieve
+		log.Info().Err(err).Msgf("Release for pipeline %v/%v/%v with id %v is no longer running", release.RepoSource, release.RepoOwner, release.RepoName, release.ID)
+		return nil
+	}
 	if err != nil {
 		log.Error().Err(err).Str("url", cancelReleaseURL).Msgf("Failed canceling release for pipeline %v/%v/%v with id %v", release.RepoSource, release.RepoOwner, release.RepoName, release.ID)
 		return
@@ -195,67 +286,183 @@ func (c *client) CancelRelease(ctx context.Context, release *contracts.Release)
 	return nil
 }
 
-func (c *client) getRequest(uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
-	return c.makeRequest("GET", uri, span, requestBody, headers, allowedStatusCodes...)
+// statusFilterQuery turns a set of statuses into filter[status] query parameters, each followed by an ampersand
+func statusFilterQuery(statuses []string) (query string) {
+	for _, s := range statuses {
+		query += fmt.Sprintf("filter[status]=%v&", url.QueryEscape(s))
+	}
+
+	return
 }
 
-func (c *client) postRequest(uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
-	return c.makeRequest("POST", uri, span, requestBody, headers, allowedStatusCodes...)
+func (c *client) MarkBuildFailed(ctx context.Context, build *contracts.Build) (err error) {
+	ctx, span := tracer.Start(ctx, "estafetteciapi.Client:MarkBuildFailed")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("pipeline", build.GetFullRepoPath()),
+		attribute.String("build.id", build.ID),
+	)
+
+	log.Info().Msgf("Marking build for pipeline %v/%v/%v with id %v as failed...", build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
+
+	bytes, err := json.Marshal(struct {
+		Status string `json:"status"`
+	}{
+		Status: "failed",
+	})
+	if err != nil {
+		return
+	}
+
+	// PUT /api/pipelines/:source/:owner/:repo/builds/:revisionOrId/status; not every api version exposes this endpoint
+	markBuildFailedURL := fmt.Sprintf("%v/api/pipelines/%v/%v/%v/builds/%v/status", c.apiBaseURL, build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
+	headers := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %v", c.getToken()),
+		"Content-Type":  "application/json",
+	}
+
+	responseBody, err := c.putRequest(ctx, markBuildFailedURL, strings.NewReader(string(bytes)), headers, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		log.Error().Err(err).Str("url", markBuildFailedURL).Msgf("Failed marking build for pipeline %v/%v/%v with id %v as failed", build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
+		return
+	}
+
+	log.Debug().Str("body", string(responseBody)).Msgf("Marked build for pipeline %v/%v/%v with id %v as failed...", build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
+
+	return nil
 }
 
-func (c *client) putRequest(uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
-	return c.makeRequest("PUT", uri, span, requestBody, headers, allowedStatusCodes...)
+// SetCredentials replaces the client id and secret, which the next GetToken logs in with; the current token stays in use
+// until then
+func (c *client) SetCredentials(clientID, clientSecret string) {
+	c.credentialsMutex.Lock()
+	defer c.credentialsMutex.Unlock()
+
+	c.clientID = clientID
+	c.clientSecret = clientSecret
 }
 
-func (c *client) deleteRequest(uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
-	return c.makeRequest("DELETE", uri, span, requestBody, headers, allowedStatusCodes...)
+func (c *client) getRequest(ctx context.Context, uri string, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	return c.makeRequest(ctx, "GET", uri, requestBody, headers, allowedStatusCodes...)
 }
 
-func (c *client) makeRequest(method, uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+func (c *client) postRequest(ctx context.Context, uri string, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	return c.makeRequest(ctx, "POST", uri, requestBody, headers, allowedStatusCodes...)
+}
 
-	// create client, in order to add headers
-	client := pester.NewExtendedClient(&http.Client{Transport: &nethttp.Transport{}})
-	client.MaxRetries = 3
-	client.Backoff = pester.ExponentialJitterBackoff
-	client.KeepLog = true
-	client.Timeout = time.Second * 10
+func (c *client) putRequest(ctx context.Context, uri string, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	return c.makeRequest(ctx, "PUT", uri, requestBody, headers, allowedStatusCodes...)
+}
 
-	request, err := http.NewRequest(method, uri, requestBody)
-	if err != nil {
-		return nil, err
-	}
+func (c *client) deleteRequest(ctx context.Context, uri string, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	return c.makeRequest(ctx, "DELETE", uri, requestBody, headers, allowedStatusCodes...)
+}
 
-	// add tracing context
-	request = request.WithContext(opentracing.ContextWithSpan(request.Context(), span))
+func (c *client) makeRequest(ctx context.Context, method, uri string, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
 
-	// collect additional information on setting up connections
-	request, ht := nethttp.TraceRequest(span.Tracer(), request)
+	// keep the request body around, it's drained by every attempt
+	var requestBodyBytes []byte
+	if requestBody != nil {
+		requestBodyBytes, err = ioutil.ReadAll(requestBody)
+		if err != nil {
+			return
+		}
+	}
 
-	// add headers
-	for k, v := range headers {
-		request.Header.Add(k, v)
+	// cap the total time of all attempts and backoffs together
+	if c.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.maxDuration)
+		defer cancel()
 	}
 
-	// perform actual request
-	response, err := client.Do(request)
+	attempts := c.maxRetries
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	var response *http.Response
+	for attempt := 1; attempt <= attempts; attempt++ {
+		response, err = c.attemptRequest(ctx, method, uri, requestBodyBytes, headers)
+		if attempt == attempts || ctx.Err() != nil || !isRetryable(method, response, err) {
+			break
+		}
+
+		// the server knows best when it can handle the request again
+		delay, ok := retryAfter(response)
+		if !ok {
+			delay = c.backoff(attempt)
+		}
+
+		// don't start waiting for an attempt that would exceed the max duration anyway
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			break
+		}
+
+		if response != nil {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		log.Debug().Err(err).Msgf("Attempt %v of %v %v failed, retrying in %v...", attempt, method, uri, delay)
+
+		// wait for the backoff unless ctx is done first, so canceling ctx interrupts the retry loop as well
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
-	ht.Finish()
 
 	if len(allowedStatusCodes) == 0 {
 		allowedStatusCodes = []int{http.StatusOK}
 	}
 
-	if !foundation.IntArrayContains(allowedStatusCodes, response.StatusCode) {
-		return nil, fmt.Errorf("%v %v responded with status code %v", method, uri, response.StatusCode)
-	}
-
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return
 	}
 
+	if !foundation.IntArrayContains(allowedStatusCodes, response.StatusCode) {
+		// keep the body short, it ends up in logs
+		if len(body) > 1024 {
+			body = body[:1024]
+		}
+		return nil, &ResponseError{
+			Method:     method,
+			URL:        uri,
+			StatusCode: response.StatusCode,
+			Body:       string(body),
+		}
+	}
+
 	return body, nil
 }
+
+func (c *client) attemptRequest(ctx context.Context, method, uri string, requestBody []byte, headers map[string]string) (response *http.Response, err error) {
+
+	// wait for the rate limiter, so a mass cleanup doesn't overload the api; every retry counts as a request as well
+	err = c.throttle(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the request carries ctx, so its cancellation and deadline abort the request and the transport traces it as a child
+	// of the span in ctx
+	request, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	// add headers
+	for k, v := range headers {
+		request.Header.Add(k, v)
+	}
+
+	// perform actual request
+	return c.httpClient.Do(request)
+}
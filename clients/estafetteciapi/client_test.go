@@ -2,10 +2,20 @@ package estafetteciapi
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	contracts "github.com/estafette/estafette-ci-contracts"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func TestGetToken(t *testing.T) {
@@ -19,7 +29,7 @@ func TestGetToken(t *testing.T) {
 		getBaseURL := os.Getenv("API_BASE_URL")
 		clientID := os.Getenv("CLIENT_ID")
 		clientSecret := os.Getenv("CLIENT_SECRET")
-		client, err := NewClient(getBaseURL, clientID, clientSecret)
+		client, err := NewClient(getBaseURL, clientID, clientSecret, 10*time.Second, 3, "exponential-jitter", time.Minute, 0, 0)
 		assert.Nil(t, err)
 
 		// act
@@ -29,3 +39,321 @@ func TestGetToken(t *testing.T) {
 		assert.True(t, len(token) > 0)
 	})
 }
+
+func TestGetBuilds(t *testing.T) {
+	t.Run("ReturnsPromptlyWhenContextIsCanceledDuringRequest", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// hang until the client gives up
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Minute })
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+
+		// act
+		_, err := client.GetBuilds(ctx, []string{"running"}, 1, 12)
+
+		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.True(t, time.Since(start) < 5*time.Second)
+	})
+
+	t.Run("ReturnsPromptlyWhenContextIsCanceledDuringBackoff", func(t *testing.T) {
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Minute })
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+
+		// act
+		_, err := client.GetBuilds(ctx, []string{"running"}, 1, 12)
+
+		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+		assert.True(t, time.Since(start) < 5*time.Second)
+	})
+
+	t.Run("SendsStatusFilters", func(t *testing.T) {
+
+		var query url.Values
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			w.Write([]byte(`{"items":[],"pagination":{"page":1,"size":12,"totalPages":0,"totalItems":0}}`))
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Millisecond })
+
+		// act
+		_, err := client.GetBuilds(context.Background(), []string{"running", "canceling"}, 1, 12)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"running", "canceling"}, query["filter[status]"])
+		assert.Equal(t, "1", query.Get("page[number]"))
+	})
+}
+
+func getTestClient(apiBaseURL string, timeout time.Duration, maxRetries int, backoff backoffStrategy) *client {
+	return &client{
+		apiBaseURL: apiBaseURL,
+		httpClient: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   timeout,
+		},
+		maxRetries: maxRetries,
+		backoff:    backoff,
+		limiter:    newRateLimiter(0, 0),
+	}
+}
+
+func TestCancelBuild(t *testing.T) {
+	t.Run("ReturnsNilIfBuildIsNotFound", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 1, func(int) time.Duration { return time.Millisecond })
+
+		// act
+		err := client.CancelBuild(context.Background(), &contracts.Build{ID: "1", RepoSource: "github.com", RepoOwner: "estafette", RepoName: "estafette-ci-api"})
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsResponseErrorIfUnauthorized", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid token"))
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 1, func(int) time.Duration { return time.Millisecond })
+
+		// act
+		err := client.CancelBuild(context.Background(), &contracts.Build{ID: "1", RepoSource: "github.com", RepoOwner: "estafette", RepoName: "estafette-ci-api"})
+
+		var responseError *ResponseError
+		if assert.True(t, errors.As(err, &responseError)) {
+			assert.Equal(t, "DELETE", responseError.Method)
+			assert.Equal(t, http.StatusUnauthorized, responseError.StatusCode)
+			assert.Equal(t, "invalid token", responseError.Body)
+		}
+	})
+}
+
+func TestMakeRequest(t *testing.T) {
+	t.Run("RetriesGetOnServerErrors", func(t *testing.T) {
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Millisecond })
+
+		// act
+		body, err := client.getRequest(context.Background(), server.URL, nil, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "ok", string(body))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("DoesNotRetryPost", func(t *testing.T) {
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Millisecond })
+
+		// act
+		_, err := client.postRequest(context.Background(), server.URL, strings.NewReader("{}"), nil)
+
+		assert.True(t, HasStatusCode(err, http.StatusServiceUnavailable))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("DoesNotRetryClientErrors", func(t *testing.T) {
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Millisecond })
+
+		// act
+		_, err := client.deleteRequest(context.Background(), server.URL, nil, nil)
+
+		assert.True(t, HasStatusCode(err, http.StatusBadRequest))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("RetriesTooManyRequestsAfterRetryAfter", func(t *testing.T) {
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Minute })
+		start := time.Now()
+
+		// act
+		_, err := client.getRequest(context.Background(), server.URL, nil, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+		assert.True(t, time.Since(start) >= time.Second)
+		assert.True(t, time.Since(start) < 10*time.Second)
+	})
+
+	t.Run("StopsRetryingIfRetryAfterExceedsMaxDuration", func(t *testing.T) {
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Millisecond })
+		client.maxDuration = time.Second
+		start := time.Now()
+
+		// act
+		_, err := client.getRequest(context.Background(), server.URL, nil, nil)
+
+		assert.True(t, HasStatusCode(err, http.StatusServiceUnavailable))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+		assert.True(t, time.Since(start) < time.Second)
+	})
+
+	t.Run("RetriesConnectionErrors", func(t *testing.T) {
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) == 1 {
+				// drop the connection without responding
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 3, func(int) time.Duration { return time.Millisecond })
+
+		// act
+		body, err := client.getRequest(context.Background(), server.URL, nil, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "ok", string(body))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	})
+}
+
+func TestThrottle(t *testing.T) {
+	t.Run("SpacesRequestsBeyondBurst", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 1, func(int) time.Duration { return time.Millisecond })
+		client.limiter = newRateLimiter(20, 1)
+		start := time.Now()
+
+		// act
+		for i := 0; i < 3; i++ {
+			_, err := client.getRequest(context.Background(), server.URL, nil, nil)
+			assert.Nil(t, err)
+		}
+
+		assert.True(t, time.Since(start) >= 90*time.Millisecond)
+	})
+
+	t.Run("ReturnsErrorIfContextIsCanceledWhileWaiting", func(t *testing.T) {
+
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 1, func(int) time.Duration { return time.Millisecond })
+		client.limiter = newRateLimiter(0.01, 1)
+		client.limiter.Allow()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// act
+		_, err := client.getRequest(ctx, server.URL, nil, nil)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	})
+}
+
+func TestSetCredentials(t *testing.T) {
+	t.Run("GetTokenLogsInWithReplacedCredentials", func(t *testing.T) {
+
+		var received contracts.Client
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&received)
+			w.Write([]byte(`{"token":"abc"}`))
+		}))
+		defer server.Close()
+
+		client := getTestClient(server.URL, time.Minute, 1, func(int) time.Duration { return time.Millisecond })
+		client.SetCredentials("old-id", "old-secret")
+		_, err := client.GetToken(context.Background())
+		assert.Nil(t, err)
+
+		// act
+		client.SetCredentials("new-id", "new-secret")
+		token, err := client.GetToken(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, "abc", token)
+		assert.Equal(t, "new-id", received.ClientID)
+		assert.Equal(t, "new-secret", received.ClientSecret)
+	})
+}
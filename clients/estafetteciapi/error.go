@@ -0,0 +1,34 @@
+package estafetteciapi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ResponseError is returned when the api responds with a status code the request doesn't allow
+type ResponseError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%v %v responded with status code %v", e.Method, e.URL, e.StatusCode)
+}
+
+// HasStatusCode returns true if err is or wraps a ResponseError with one of the status codes
+func HasStatusCode(err error, statusCodes ...int) bool {
+	var responseError *ResponseError
+	if !errors.As(err, &responseError) {
+		return false
+	}
+
+	for _, sc := range statusCodes {
+		if responseError.StatusCode == sc {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package estafetteciapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+var rateLimiterWaitSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "estafette_ci_api_client_rate_limiter_wait_seconds",
+	Help:    "Time requests to the estafette ci api waited for the client-side rate limiter.",
+	Buckets: []float64{0.001, 0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+})
+
+// newRateLimiter returns a token bucket allowing qps requests per second with bursts of up to burst requests; zero or
+// negative qps disables rate limiting
+func newRateLimiter(qps float64, burst int) *rate.Limiter {
+	if qps <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return rate.NewLimiter(rate.Limit(qps), burst)
+}
+
+// throttle blocks until the rate limiter allows another request or ctx is done, and records how long it waited
+func (c *client) throttle(ctx context.Context) (err error) {
+	start := time.Now()
+	err = c.limiter.Wait(ctx)
+	rateLimiterWaitSeconds.Observe(time.Since(start).Seconds())
+
+	return
+}
@@ -0,0 +1,71 @@
+package estafetteciapi
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// backoffStrategy returns the time to wait before the next attempt, given the number of the failed attempt
+type backoffStrategy func(attempt int) time.Duration
+
+var backoffStrategies = map[string]backoffStrategy{
+	"exponential":        exponentialBackoff,
+	"exponential-jitter": func(attempt int) time.Duration { return exponentialBackoff(attempt) + jitter() },
+	"linear":             linearBackoff,
+	"linear-jitter":      func(attempt int) time.Duration { return linearBackoff(attempt) + jitter() },
+}
+
+func exponentialBackoff(attempt int) time.Duration {
+	return time.Duration(1<<uint(attempt)) * time.Second
+}
+
+func linearBackoff(attempt int) time.Duration {
+	return time.Duration(attempt) * time.Second
+}
+
+// jitter returns up to a second of random delay, so clients failing at the same time don't retry in lockstep
+func jitter() time.Duration {
+	return time.Duration(rand.Intn(1000)) * time.Millisecond
+}
+
+// isRetryable returns true if a failed attempt is worth retrying; only idempotent methods get retried and only on
+// connection errors, 429 and 5xx, never on other 4xx responses
+func isRetryable(method string, response *http.Response, err error) bool {
+	if method != http.MethodGet && method != http.MethodDelete {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+}
+
+// retryAfter returns the delay requested by the Retry-After header, either in seconds or as http date
+func retryAfter(response *http.Response) (delay time.Duration, ok bool) {
+	if response == nil {
+		return 0, false
+	}
+
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
@@ -1,35 +1,105 @@
 package kubernetesapi
 
 import (
+	"bufio"
 	"context"
-
-	"github.com/opentracing/opentracing-go"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/internal/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/pager"
+	"k8s.io/client-go/util/retry"
 )
 
+var tracer = otel.Tracer("github.com/estafette/estafette-ci-hanging-job-cleaner/clients/kubernetesapi")
+
 type Client interface {
-	GetJobs(ctx context.Context) (jobs []batchv1.Job, err error)
-	GetConfigMaps(ctx context.Context) (configmaps []v1.ConfigMap, err error)
-	GetSecrets(ctx context.Context) (secrets []v1.Secret, err error)
+	Ping(ctx context.Context) (err error)
+
+	GetJobs(ctx context.Context, handler func(job batchv1.Job) error) (err error)
+	GetConfigMaps(ctx context.Context, handler func(configmap v1.ConfigMap) error) (err error)
+	GetSecrets(ctx context.Context, handler func(secret v1.Secret) error) (err error)
 
 	DeleteJob(ctx context.Context, job batchv1.Job) (err error)
 	DeleteConfigMap(ctx context.Context, configmap v1.ConfigMap) (err error)
 	DeleteSecret(ctx context.Context, secret v1.Secret) (err error)
+	DeletePod(ctx context.Context, pod v1.Pod) (err error)
+	ForceDeleteJob(ctx context.Context, jobName string) (err error)
+	ForceDeleteJobPods(ctx context.Context, jobName string) (err error)
+	RemoveFinalizers(ctx context.Context, kind, name string) (err error)
+
+	GetJob(ctx context.Context, jobName string) (job *batchv1.Job, err error)
+	AnnotateJob(ctx context.Context, jobName string, annotations map[string]string) (err error)
+	GetJobPods(ctx context.Context, jobName string) (pods []v1.Pod, err error)
+	GetLastLogTime(ctx context.Context, pod v1.Pod, since time.Duration) (lastLogTime *time.Time, err error)
+	GetNode(ctx context.Context, nodeName string) (node *v1.Node, err error)
+
+	AppendToConfigMap(ctx context.Context, name, key, line string, maxLines int) (err error)
+	GetConfigMapData(ctx context.Context, name string) (data map[string]string, err error)
+	SetConfigMapData(ctx context.Context, name string, data map[string]string) (err error)
+
+	Watch(ctx context.Context, onUpsert func(object metav1.Object), onDelete func(object metav1.Object)) (err error)
+
+	RunWithLeaderElection(ctx context.Context, leaseName, leaseNamespace, identity string, run func(ctx context.Context)) (err error)
+
+	SetDeletePolicies(deletePolicies map[string]DeletePolicy, precondition string) (err error)
 }
 
-// NewClient returns a new kubernetesapi.Client
-func NewClient(namespace string) (Client, error) {
+// NewClient returns a new kubernetesapi.Client, which only lists jobs, configmaps and secrets matching the label and
+// field selectors, in pages of pageSize; requests are limited to qps per second with bursts of up to burst, zero falls
+// back to the client-go defaults. Jobs, pods, configmaps and secrets get deleted according to the delete policy for
+// their kind, with the precondition on their identity (none, uid or resource-version)
+func NewClient(namespace, labelSelector, fieldSelector string, pageSize int64, qps float32, burst int, deletePolicies map[string]DeletePolicy, precondition string) (Client, error) {
+
+	// validate selectors up front rather than failing on the first list call
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("label selector %v is invalid: %w", labelSelector, err)
+	}
+	if selector.Empty() {
+		// an empty selector matches everything, which would clean every job, configmap and secret in the namespace
+		return nil, fmt.Errorf("label selector is empty, set a label selector or required labels to select the objects to clean")
+	}
+	_, err = fields.ParseSelector(fieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("field selector %v is invalid: %w", fieldSelector, err)
+	}
+	err = validateDeletePolicies(deletePolicies, precondition)
+	if err != nil {
+		return nil, err
+	}
 
 	// create kubernetes api client
 	kubeClientConfig, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, err
 	}
+	kubeClientConfig.QPS = qps
+	kubeClientConfig.Burst = burst
+	registerMetrics()
+
 	// creates the clientset
 	kubeClientset, err := kubernetes.NewForConfig(kubeClientConfig)
 	if err != nil {
@@ -37,86 +107,167 @@ func NewClient(namespace string) (Client, error) {
 	}
 
 	return &client{
-		kubeClientset: kubeClientset,
-		namespace:     namespace,
+		kubeClientset:  kubeClientset,
+		namespace:      namespace,
+		labelSelector:  labelSelector,
+		fieldSelector:  fieldSelector,
+		pageSize:       pageSize,
+		deletePolicies: deletePolicies,
+		precondition:   precondition,
 	}, nil
 }
 
 type client struct {
-	kubeClientset *kubernetes.Clientset
-	namespace     string
+	kubeClientset  kubernetes.Interface
+	namespace      string
+	labelSelector  string
+	fieldSelector  string
+	pageSize       int64
+	deletePolicies map[string]DeletePolicy
+	precondition   string
+
+	// deletePoliciesMutex guards deletePolicies and precondition, which get replaced when the config file changes
+	deletePoliciesMutex sync.RWMutex
 }
 
-func (c *client) GetJobs(ctx context.Context) (jobs []batchv1.Job, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "kubernetesapi.Client:GetJobs")
-	defer span.Finish()
-
-	log.Info().Msgf("Retrieving jobs with label createdBy=estafette in namespace %v...", c.namespace)
+// Ping checks whether the kubernetes api is reachable and jobs in the namespace can be listed
+func (c *client) Ping(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:Ping")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(attribute.String("namespace", c.namespace))
 
-	jobsList, err := c.kubeClientset.BatchV1().Jobs(c.namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "createdBy=estafette",
+	_, err = c.kubeClientset.BatchV1().Jobs(c.namespace).List(ctx, metav1.ListOptions{
+		Limit: 1,
 	})
 	if err != nil {
 		return
 	}
 
-	jobs = jobsList.Items
-
-	log.Info().Msgf("Retrieved %v jobs with label createdBy=estafette in namespace %v", len(jobs), c.namespace)
-
-	return jobs, nil
+	return nil
 }
 
-func (c *client) GetConfigMaps(ctx context.Context) (configmaps []v1.ConfigMap, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "kubernetesapi.Client:GetConfigMaps")
-	defer span.Finish()
-
-	log.Info().Msgf("Retrieving configmaps with label createdBy=estafette in namespace %v...", c.namespace)
-
-	configmapsList, err := c.kubeClientset.CoreV1().ConfigMaps(c.namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "createdBy=estafette",
+func (c *client) GetJobs(ctx context.Context, handler func(job batchv1.Job) error) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:GetJobs")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(attribute.String("namespace", c.namespace))
+
+	log.Info().Msgf("Retrieving jobs with label selector %v and field selector %v in namespace %v...", c.labelSelector, c.fieldSelector, c.namespace)
+
+	count := 0
+	err = c.eachListItem(ctx, func(options metav1.ListOptions) (runtime.Object, error) {
+		return c.kubeClientset.BatchV1().Jobs(c.namespace).List(ctx, options)
+	}, func(obj runtime.Object) error {
+		job, ok := obj.(*batchv1.Job)
+		if !ok {
+			return fmt.Errorf("list item of type %T is not a job", obj)
+		}
+		count++
+		return handler(*job)
 	})
 	if err != nil {
 		return
 	}
 
-	configmaps = configmapsList.Items
+	log.Info().Msgf("Retrieved %v jobs with label selector %v and field selector %v in namespace %v", count, c.labelSelector, c.fieldSelector, c.namespace)
 
-	log.Info().Msgf("Retrieved %v configmaps with label createdBy=estafette in namespace %v", len(configmaps), c.namespace)
-
-	return configmaps, nil
+	return nil
 }
 
-func (c *client) GetSecrets(ctx context.Context) (secrets []v1.Secret, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "kubernetesapi.Client:GetSecrets")
-	defer span.Finish()
+func (c *client) GetConfigMaps(ctx context.Context, handler func(configmap v1.ConfigMap) error) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:GetConfigMaps")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(attribute.String("namespace", c.namespace))
+
+	log.Info().Msgf("Retrieving configmaps with label selector %v and field selector %v in namespace %v...", c.labelSelector, c.fieldSelector, c.namespace)
+
+	count := 0
+	err = c.eachListItem(ctx, func(options metav1.ListOptions) (runtime.Object, error) {
+		return c.kubeClientset.CoreV1().ConfigMaps(c.namespace).List(ctx, options)
+	}, func(obj runtime.Object) error {
+		configmap, ok := obj.(*v1.ConfigMap)
+		if !ok {
+			return fmt.Errorf("list item of type %T is not a configmap", obj)
+		}
+		count++
+		return handler(*configmap)
+	})
+	if err != nil {
+		return
+	}
+
+	log.Info().Msgf("Retrieved %v configmaps with label selector %v and field selector %v in namespace %v", count, c.labelSelector, c.fieldSelector, c.namespace)
 
-	log.Info().Msgf("Retrieving secrets with label createdBy=estafette in namespace %v...", c.namespace)
+	return nil
+}
 
-	secretsList, err := c.kubeClientset.CoreV1().Secrets(c.namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "createdBy=estafette",
+func (c *client) GetSecrets(ctx context.Context, handler func(secret v1.Secret) error) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:GetSecrets")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(attribute.String("namespace", c.namespace))
+
+	log.Info().Msgf("Retrieving secrets with label selector %v and field selector %v in namespace %v...", c.labelSelector, c.fieldSelector, c.namespace)
+
+	count := 0
+	err = c.eachListItem(ctx, func(options metav1.ListOptions) (runtime.Object, error) {
+		return c.kubeClientset.CoreV1().Secrets(c.namespace).List(ctx, options)
+	}, func(obj runtime.Object) error {
+		secret, ok := obj.(*v1.Secret)
+		if !ok {
+			return fmt.Errorf("list item of type %T is not a secret", obj)
+		}
+		count++
+		return handler(*secret)
 	})
 	if err != nil {
 		return
 	}
 
-	secrets = secretsList.Items
+	log.Info().Msgf("Retrieved %v secrets with label selector %v and field selector %v in namespace %v", count, c.labelSelector, c.fieldSelector, c.namespace)
 
-	log.Info().Msgf("Retrieved %v secrets with label createdBy=estafette in namespace %v", len(secrets), c.namespace)
+	return nil
+}
 
-	return secrets, nil
+// maxListRestarts is the number of times paging starts over after its continue token expired
+const maxListRestarts = 3
+
+// eachListItem lists objects in pages of pageSize, so large namespaces don't have to be held in memory or listed in a
+// single call, and passes each item to the handler as soon as its page has been retrieved; since the handler deletes
+// objects while paging, the continue token can expire halfway, in which case paging starts over rather than falling
+// back to the single unbounded list call paging is meant to avoid
+func (c *client) eachListItem(ctx context.Context, list func(options metav1.ListOptions) (runtime.Object, error), handler func(obj runtime.Object) error) (err error) {
+	for restarts := 0; ; restarts++ {
+		listPager := pager.New(pager.SimplePageFunc(list))
+		listPager.PageSize = c.pageSize
+		listPager.FullListIfExpired = false
+
+		err = listPager.EachListItem(ctx, metav1.ListOptions{
+			LabelSelector: c.labelSelector,
+			FieldSelector: c.fieldSelector,
+		}, handler)
+		if !k8serrors.IsResourceExpired(err) || restarts >= maxListRestarts {
+			return err
+		}
+
+		log.Info().Err(err).Msgf("Continue token expired while paging through namespace %v, starting over from the first page...", c.namespace)
+	}
 }
 
 func (c *client) DeleteJob(ctx context.Context, job batchv1.Job) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "kubernetesapi.Client:DeleteSecret")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:DeleteJob")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", job.Name),
+	)
 
 	log.Info().Msgf("Deleting job %v in namespace %v started at %v...", job.Name, c.namespace, job.CreationTimestamp.Time)
 
-	propagationPolicy := metav1.DeletePropagationForeground
-	err = c.kubeClientset.BatchV1().Jobs(c.namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
-		PropagationPolicy: &propagationPolicy,
-	})
+	options := c.deleteOptions("job", job.ObjectMeta)
+	err = c.kubeClientset.BatchV1().Jobs(c.namespace).Delete(ctx, job.Name, options)
+	if options.Preconditions != nil && k8serrors.IsConflict(err) {
+		log.Info().Msgf("Job %v in namespace %v got recreated or changed since it was listed, not deleting it", job.Name, c.namespace)
+		return fmt.Errorf("job %v in namespace %v: %w", job.Name, c.namespace, ErrPreconditionFailed)
+	}
 	if err != nil {
 		return
 	}
@@ -125,15 +276,21 @@ func (c *client) DeleteJob(ctx context.Context, job batchv1.Job) (err error) {
 }
 
 func (c *client) DeleteConfigMap(ctx context.Context, configmap v1.ConfigMap) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "kubernetesapi.Client:DeleteConfigMap")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:DeleteConfigMap")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", configmap.Name),
+	)
 
 	log.Info().Msgf("Deleting configmap %v in namespace %v started at %v...", configmap.Name, c.namespace, configmap.CreationTimestamp.Time)
 
-	propagationPolicy := metav1.DeletePropagationForeground
-	err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Delete(ctx, configmap.Name, metav1.DeleteOptions{
-		PropagationPolicy: &propagationPolicy,
-	})
+	options := c.deleteOptions("configmap", configmap.ObjectMeta)
+	err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Delete(ctx, configmap.Name, options)
+	if options.Preconditions != nil && k8serrors.IsConflict(err) {
+		log.Info().Msgf("Configmap %v in namespace %v got recreated or changed since it was listed, not deleting it", configmap.Name, c.namespace)
+		return fmt.Errorf("configmap %v in namespace %v: %w", configmap.Name, c.namespace, ErrPreconditionFailed)
+	}
 	if err != nil {
 		return
 	}
@@ -142,14 +299,93 @@ func (c *client) DeleteConfigMap(ctx context.Context, configmap v1.ConfigMap) (e
 }
 
 func (c *client) DeleteSecret(ctx context.Context, secret v1.Secret) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "kubernetesapi.Client:DeleteSecret")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:DeleteSecret")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", secret.Name),
+	)
 
 	log.Info().Msgf("Deleting secret %v in namespace %v started at %v...", secret.Name, c.namespace, secret.CreationTimestamp.Time)
 
-	propagationPolicy := metav1.DeletePropagationForeground
-	err = c.kubeClientset.CoreV1().Secrets(c.namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{
-		PropagationPolicy: &propagationPolicy,
+	options := c.deleteOptions("secret", secret.ObjectMeta)
+	err = c.kubeClientset.CoreV1().Secrets(c.namespace).Delete(ctx, secret.Name, options)
+	if options.Preconditions != nil && k8serrors.IsConflict(err) {
+		log.Info().Msgf("Secret %v in namespace %v got recreated or changed since it was listed, not deleting it", secret.Name, c.namespace)
+		return fmt.Errorf("secret %v in namespace %v: %w", secret.Name, c.namespace, ErrPreconditionFailed)
+	}
+	if err != nil {
+		return
+	}
+
+	return nil
+}
+
+func (c *client) DeletePod(ctx context.Context, pod v1.Pod) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:DeletePod")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", pod.Name),
+	)
+
+	log.Info().Msgf("Deleting pod %v in namespace %v started at %v...", pod.Name, c.namespace, pod.CreationTimestamp.Time)
+
+	options := c.deleteOptions("pod", pod.ObjectMeta)
+	err = c.kubeClientset.CoreV1().Pods(c.namespace).Delete(ctx, pod.Name, options)
+	if options.Preconditions != nil && k8serrors.IsConflict(err) {
+		log.Info().Msgf("Pod %v in namespace %v got recreated or changed since it was listed, not deleting it", pod.Name, c.namespace)
+		return fmt.Errorf("pod %v in namespace %v: %w", pod.Name, c.namespace, ErrPreconditionFailed)
+	}
+	if err != nil {
+		return
+	}
+
+	return nil
+}
+
+func (c *client) ForceDeleteJob(ctx context.Context, jobName string) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:ForceDeleteJob")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", jobName),
+	)
+
+	log.Info().Msgf("Force deleting job %v and its pods in namespace %v...", jobName, c.namespace)
+
+	gracePeriodSeconds := int64(0)
+	propagationPolicy := metav1.DeletePropagationBackground
+
+	err = c.kubeClientset.BatchV1().Jobs(c.namespace).Delete(ctx, jobName, metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriodSeconds,
+		PropagationPolicy:  &propagationPolicy,
+	})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return
+	}
+
+	// pods of a job that never acknowledged its cancellation can hang in terminating, so delete them without grace period as well
+	return c.ForceDeleteJobPods(ctx, jobName)
+}
+
+// ForceDeleteJobPods deletes the pods of a job without grace period, so a foreground deletion of the job isn't held up
+// by them
+func (c *client) ForceDeleteJobPods(ctx context.Context, jobName string) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:ForceDeleteJobPods")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", jobName),
+	)
+
+	log.Info().Msgf("Force deleting pods of job %v in namespace %v...", jobName, c.namespace)
+
+	gracePeriodSeconds := int64(0)
+	err = c.kubeClientset.CoreV1().Pods(c.namespace).DeleteCollection(ctx, metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriodSeconds,
+	}, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%v", jobName),
 	})
 	if err != nil {
 		return
@@ -157,3 +393,436 @@ func (c *client) DeleteSecret(ctx context.Context, secret v1.Secret) (err error)
 
 	return nil
 }
+
+// RemoveFinalizers clears the finalizers of a job, pod, configmap or secret, so an object stuck in terminating gets
+// removed regardless of what the finalizers were waiting for; an object that doesn't exist anymore is ignored
+func (c *client) RemoveFinalizers(ctx context.Context, kind, name string) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:RemoveFinalizers")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("kind", kind),
+		attribute.String("name", name),
+	)
+
+	log.Warn().Msgf("Removing finalizers of %v %v in namespace %v...", kind, name, c.namespace)
+
+	patch := []byte(`{"metadata":{"finalizers":null}}`)
+	switch kind {
+	case "job":
+		_, err = c.kubeClientset.BatchV1().Jobs(c.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "pod":
+		_, err = c.kubeClientset.CoreV1().Pods(c.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "configmap":
+		_, err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "secret":
+		_, err = c.kubeClientset.CoreV1().Secrets(c.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	default:
+		return fmt.Errorf("removing finalizers of kind %v is not supported", kind)
+	}
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return
+	}
+
+	return nil
+}
+
+// GetJob returns the job by name, or nil if it doesn't exist
+func (c *client) GetJob(ctx context.Context, jobName string) (job *batchv1.Job, err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:GetJob")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", jobName),
+	)
+
+	job, err = c.kubeClientset.BatchV1().Jobs(c.namespace).Get(ctx, jobName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// AnnotateJob merges annotations into those of a job; a job that doesn't exist is ignored
+func (c *client) AnnotateJob(ctx context.Context, jobName string, annotations map[string]string) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:AnnotateJob")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", jobName),
+	)
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	_, err = c.kubeClientset.BatchV1().Jobs(c.namespace).Patch(ctx, jobName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return
+	}
+
+	return nil
+}
+
+// GetJobPods returns the pods created for a job
+func (c *client) GetJobPods(ctx context.Context, jobName string) (pods []v1.Pod, err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:GetJobPods")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", jobName),
+	)
+
+	podList, err := c.kubeClientset.CoreV1().Pods(c.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%v", jobName),
+	})
+	if err != nil {
+		return
+	}
+
+	return podList.Items, nil
+}
+
+// GetNode returns the node by name, or nil if it doesn't exist (anymore)
+func (c *client) GetNode(ctx context.Context, nodeName string) (node *v1.Node, err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:GetNode")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(attribute.String("name", nodeName))
+
+	node, err = c.kubeClientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// GetLastLogTime returns the time of the most recent log line of any container of a pod within since, or in its entire
+// log if since is 0, or nil if none of its containers logged anything in that window; only the last line of each
+// container gets transferred
+func (c *client) GetLastLogTime(ctx context.Context, pod v1.Pod, since time.Duration) (lastLogTime *time.Time, err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:GetLastLogTime")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", pod.Namespace),
+		attribute.String("name", pod.Name),
+	)
+
+	// the api rejects a since of less than a second, leaving it out looks at the entire log
+	var sinceSeconds *int64
+	if since > 0 {
+		seconds := int64(since.Seconds())
+		sinceSeconds = &seconds
+	}
+	tailLines := int64(1)
+
+	for _, container := range pod.Spec.Containers {
+		stream, err := c.kubeClientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+			Container:    container.Name,
+			SinceSeconds: sinceSeconds,
+			Timestamps:   true,
+			TailLines:    &tailLines,
+		}).Stream(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		logTime, err := lastLogLineTime(stream)
+		stream.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if logTime != nil && (lastLogTime == nil || logTime.After(*lastLogTime)) {
+			lastLogTime = logTime
+		}
+	}
+
+	return lastLogTime, nil
+}
+
+// lastLogLineTime parses the rfc3339 timestamp the log api prefixes lines with when asked for timestamps, returning
+// the one of the last line or nil if there are no lines
+func lastLogLineTime(reader io.Reader) (logTime *time.Time, err error) {
+	bufferedReader := bufio.NewReader(reader)
+
+	// lines longer than the buffer come in fragments, only the first one starts with a timestamp
+	lineStart := true
+	for {
+		fragment, isPrefix, err := bufferedReader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if lineStart && len(fragment) > 0 {
+			timestamp := strings.SplitN(string(fragment), " ", 2)[0]
+			t, err := time.Parse(time.RFC3339Nano, timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("log line timestamp %v is invalid: %w", timestamp, err)
+			}
+			logTime = &t
+		}
+		lineStart = !isPrefix
+	}
+
+	return logTime, nil
+}
+
+// AppendToConfigMap appends a line to a key of a configmap, creating it if it doesn't exist, and drops the oldest lines
+// beyond maxLines so the configmap acts as a ring buffer; it gets no labels so the label selector never makes it get cleaned itself
+func (c *client) AppendToConfigMap(ctx context.Context, name, key, line string, maxLines int) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:AppendToConfigMap")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", name),
+	)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configmap, err := c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: c.namespace,
+				},
+				Data: map[string]string{
+					key: line + "\n",
+				},
+			}, metav1.CreateOptions{})
+			if k8serrors.IsAlreadyExists(err) {
+				// created concurrently, retry as update
+				return k8serrors.NewConflict(v1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		lines := strings.Split(strings.TrimSuffix(configmap.Data[key], "\n"), "\n")
+		if len(lines) == 1 && lines[0] == "" {
+			lines = []string{}
+		}
+		lines = append(lines, line)
+		if maxLines > 0 && len(lines) > maxLines {
+			lines = lines[len(lines)-maxLines:]
+		}
+
+		if configmap.Data == nil {
+			configmap.Data = map[string]string{}
+		}
+		configmap.Data[key] = strings.Join(lines, "\n") + "\n"
+
+		_, err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Update(ctx, configmap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// GetConfigMapData returns the data of a configmap, or nil if it doesn't exist
+func (c *client) GetConfigMapData(ctx context.Context, name string) (data map[string]string, err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:GetConfigMapData")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", name),
+	)
+
+	configmap, err := c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return configmap.Data, nil
+}
+
+// SetConfigMapData replaces the data of a configmap, creating it if it doesn't exist; like the audit configmap it gets
+// no labels so the label selector never makes it get cleaned itself
+func (c *client) SetConfigMapData(ctx context.Context, name string, data map[string]string) (err error) {
+	ctx, span := tracer.Start(ctx, "kubernetesapi.Client:SetConfigMapData")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(
+		attribute.String("namespace", c.namespace),
+		attribute.String("name", name),
+	)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configmap, err := c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: c.namespace,
+				},
+				Data: data,
+			}, metav1.CreateOptions{})
+			if k8serrors.IsAlreadyExists(err) {
+				// created concurrently, retry as update
+				return k8serrors.NewConflict(v1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		configmap.Data = data
+
+		_, err = c.kubeClientset.CoreV1().ConfigMaps(c.namespace).Update(ctx, configmap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// RunWithLeaderElection executes run only while holding the lease, campaigning again after losing it until ctx is done;
+// the lease gets released when ctx is canceled so another replica can take over without waiting for it to expire
+func (c *client) RunWithLeaderElection(ctx context.Context, leaseName, leaseNamespace, identity string, run func(ctx context.Context)) (err error) {
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: leaseNamespace,
+		},
+		Client: c.kubeClientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	terms := &leaderTerms{}
+	leaderElector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				terms.run(ctx, run)
+			},
+			OnStoppedLeading: func() {
+				log.Info().Msgf("Stopped leading lease %v in namespace %v as %v", leaseName, leaseNamespace, identity)
+			},
+			OnNewLeader: func(currentIdentity string) {
+				if currentIdentity != identity {
+					log.Info().Msgf("Lease %v in namespace %v is held by %v", leaseName, leaseNamespace, currentIdentity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	for ctx.Err() == nil {
+		log.Info().Msgf("Acquiring lease %v in namespace %v as %v...", leaseName, leaseNamespace, identity)
+		leaderElector.Run(ctx)
+
+		// the leader elector doesn't wait for run to return after losing the lease, so campaigning again right away
+		// could overlap two terms
+		terms.wait()
+	}
+
+	return nil
+}
+
+// leaderTerms keeps track of the run of a leader term, which the leader elector starts in a goroutine of its own
+type leaderTerms struct {
+	mutex   sync.Mutex
+	running sync.WaitGroup
+}
+
+// run runs the term, unless it ended before its goroutine got started
+func (t *leaderTerms) run(ctx context.Context, run func(ctx context.Context)) {
+	t.mutex.Lock()
+	if ctx.Err() != nil {
+		t.mutex.Unlock()
+		return
+	}
+	t.running.Add(1)
+	t.mutex.Unlock()
+	defer t.running.Done()
+
+	run(ctx)
+}
+
+// wait returns once the run of a term that ended has returned; any term started afterwards sees its context is done
+func (t *leaderTerms) wait() {
+	t.mutex.Lock()
+	t.mutex.Unlock()
+
+	t.running.Wait()
+}
+
+// Watch runs shared informers for jobs, pods, configmaps and secrets matching the selectors and passes every added or
+// updated object to onUpsert and every deleted one to onDelete, until ctx is done
+func (c *client) Watch(ctx context.Context, onUpsert func(object metav1.Object), onDelete func(object metav1.Object)) (err error) {
+
+	log.Info().Msgf("Watching jobs, pods, configmaps and secrets with label selector %v and field selector %v in namespace %v...", c.labelSelector, c.fieldSelector, c.namespace)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(c.kubeClientset, 0,
+		informers.WithNamespace(c.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = c.labelSelector
+			options.FieldSelector = c.fieldSelector
+		}),
+	)
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if object, ok := obj.(metav1.Object); ok {
+				onUpsert(object)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if object, ok := obj.(metav1.Object); ok {
+				onUpsert(object)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			// the informer hands out a tombstone if it missed the actual delete event
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if object, ok := obj.(metav1.Object); ok {
+				onDelete(object)
+			}
+		},
+	}
+
+	factory.Batch().V1().Jobs().Informer().AddEventHandler(handler)
+	factory.Core().V1().Pods().Informer().AddEventHandler(handler)
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(handler)
+	factory.Core().V1().Secrets().Informer().AddEventHandler(handler)
+
+	factory.Start(ctx.Done())
+
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("informer for %v failed to sync", informerType)
+		}
+	}
+
+	log.Info().Msgf("Synced informers for namespace %v", c.namespace)
+
+	<-ctx.Done()
+
+	return nil
+}
@@ -0,0 +1,583 @@
+package kubernetesapi
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGetConfigMaps(t *testing.T) {
+	t.Run("PassesEachMatchingConfigMapToHandler", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient(
+			getConfigMap("build-1", map[string]string{"createdBy": "estafette"}),
+			getConfigMap("build-2", map[string]string{"createdBy": "estafette"}),
+			getConfigMap("build-3", map[string]string{"createdBy": "estafette"}),
+			getConfigMap("other", map[string]string{"createdBy": "someone-else"}),
+		)
+		names := []string{}
+
+		// act
+		err := client.GetConfigMaps(ctx, func(configmap v1.ConfigMap) error {
+			names = append(names, configmap.Name)
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []string{"build-1", "build-2", "build-3"}, names)
+	})
+
+	t.Run("StopsAtFirstHandlerError", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient(
+			getConfigMap("build-1", map[string]string{"createdBy": "estafette"}),
+			getConfigMap("build-2", map[string]string{"createdBy": "estafette"}),
+		)
+		handlerErr := errors.New("handler failed")
+		calls := 0
+
+		// act
+		err := client.GetConfigMaps(ctx, func(configmap v1.ConfigMap) error {
+			calls++
+			return handlerErr
+		})
+
+		assert.Equal(t, handlerErr, err)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	t.Run("ReturnsErrorForEmptyLabelSelector", func(t *testing.T) {
+
+		// act
+		_, err := NewClient("estafette-ci-jobs", "", "", 100, 5, 10, nil, PreconditionNone)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "label selector is empty")
+	})
+
+	t.Run("ReturnsErrorForInvalidLabelSelector", func(t *testing.T) {
+
+		// act
+		_, err := NewClient("estafette-ci-jobs", "createdBy in (", "", 100, 5, 10, nil, PreconditionNone)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "is invalid")
+	})
+}
+
+func TestEachListItem(t *testing.T) {
+	t.Run("RequestsPagesOfPageSizeUsingContinueTokens", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+		client.pageSize = 2
+		list, requests := getPagedConfigMapList(t, []string{"build-1", "build-2", "build-3", "build-4", "build-5"}, 2, 0)
+		names := []string{}
+
+		// act
+		err := client.eachListItem(ctx, list, func(obj runtime.Object) error {
+			names = append(names, obj.(*v1.ConfigMap).Name)
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"build-1", "build-2", "build-3", "build-4", "build-5"}, names)
+		assert.Equal(t, []string{"", "2", "4"}, *requests)
+	})
+
+	t.Run("StartsOverWhenContinueTokenExpires", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+		client.pageSize = 2
+		list, requests := getPagedConfigMapList(t, []string{"build-1", "build-2", "build-3"}, 2, 1)
+		names := []string{}
+
+		// act
+		err := client.eachListItem(ctx, list, func(obj runtime.Object) error {
+			names = append(names, obj.(*v1.ConfigMap).Name)
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"build-1", "build-2", "build-1", "build-2", "build-3"}, names)
+		assert.Equal(t, []string{"", "2", "", "2"}, *requests)
+	})
+
+	t.Run("GivesUpAfterMaxRestarts", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+		client.pageSize = 2
+		list, _ := getPagedConfigMapList(t, []string{"build-1", "build-2", "build-3"}, 2, maxListRestarts+1)
+
+		// act
+		err := client.eachListItem(ctx, list, func(obj runtime.Object) error {
+			return nil
+		})
+
+		assert.True(t, k8serrors.IsResourceExpired(err))
+	})
+}
+
+// getPagedConfigMapList returns a list function serving names in pages the way the api server does, checking the
+// requested limit and failing the first expirations requests for a next page with an expired continue token; it
+// records the continue token of every request
+func getPagedConfigMapList(t *testing.T, names []string, pageSize int64, expirations int) (list func(options metav1.ListOptions) (runtime.Object, error), requests *[]string) {
+	requests = &[]string{}
+
+	return func(options metav1.ListOptions) (runtime.Object, error) {
+		*requests = append(*requests, options.Continue)
+		assert.Equal(t, pageSize, options.Limit)
+
+		start := 0
+		if options.Continue != "" {
+			if expirations > 0 {
+				expirations--
+				return nil, k8serrors.NewResourceExpired("the provided continue parameter is too old")
+			}
+			start, _ = strconv.Atoi(options.Continue)
+		}
+		end := start + int(options.Limit)
+		if end > len(names) {
+			end = len(names)
+		}
+
+		configmapList := &v1.ConfigMapList{}
+		for _, name := range names[start:end] {
+			configmapList.Items = append(configmapList.Items, *getConfigMap(name, nil))
+		}
+		if end < len(names) {
+			configmapList.Continue = strconv.Itoa(end)
+		}
+
+		return configmapList, nil
+	}, requests
+}
+
+func TestAnnotateJob(t *testing.T) {
+	t.Run("MergesAnnotationsIntoExistingOnes", func(t *testing.T) {
+
+		ctx := context.Background()
+		job := getJob("build-estafette-ci-api-1")
+		job.Annotations = map[string]string{"existing": "value"}
+		client := getFakeClient(job)
+
+		// act
+		err := client.AnnotateJob(ctx, "build-estafette-ci-api-1", map[string]string{"added": "value"})
+
+		assert.Nil(t, err)
+		annotatedJob, err := client.GetJob(ctx, "build-estafette-ci-api-1")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"existing": "value", "added": "value"}, annotatedJob.Annotations)
+	})
+
+	t.Run("IgnoresMissingJob", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		err := client.AnnotateJob(ctx, "build-estafette-ci-api-1", map[string]string{"added": "value"})
+
+		assert.Nil(t, err)
+	})
+}
+
+func TestGetJob(t *testing.T) {
+	t.Run("ReturnsNilIfJobDoesNotExist", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		job, err := client.GetJob(ctx, "build-estafette-ci-api-1")
+
+		assert.Nil(t, err)
+		assert.Nil(t, job)
+	})
+}
+
+func TestDeleteJob(t *testing.T) {
+	t.Run("ReturnsErrPreconditionFailedIfJobChangedSinceItWasListed", func(t *testing.T) {
+
+		ctx := context.Background()
+		job := getJob("build-estafette-ci-api-1")
+		client := getFakeClient(job)
+		client.precondition = PreconditionUID
+		client.kubeClientset.(*fake.Clientset).PrependReactor("delete", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, k8serrors.NewConflict(batchv1.Resource("jobs"), job.Name, errors.New("precondition failed: UID in precondition does not match UID in object"))
+		})
+
+		// act
+		err := client.DeleteJob(ctx, *job)
+
+		assert.True(t, errors.Is(err, ErrPreconditionFailed))
+	})
+
+	t.Run("ReturnsConflictAsIsWithoutPrecondition", func(t *testing.T) {
+
+		ctx := context.Background()
+		job := getJob("build-estafette-ci-api-1")
+		client := getFakeClient(job)
+		client.precondition = PreconditionNone
+		client.kubeClientset.(*fake.Clientset).PrependReactor("delete", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, k8serrors.NewConflict(batchv1.Resource("jobs"), job.Name, errors.New("the object has been modified"))
+		})
+
+		// act
+		err := client.DeleteJob(ctx, *job)
+
+		assert.False(t, errors.Is(err, ErrPreconditionFailed))
+		assert.True(t, k8serrors.IsConflict(err))
+	})
+}
+
+func TestDeletePod(t *testing.T) {
+	t.Run("ReturnsErrPreconditionFailedIfPodChangedSinceItWasListed", func(t *testing.T) {
+
+		ctx := context.Background()
+		pod := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "build-estafette-ci-api-1-abcde", Namespace: "estafette-ci-jobs", UID: "1"}}
+		client := getFakeClient()
+		client.precondition = PreconditionResourceVersion
+		client.kubeClientset.(*fake.Clientset).PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, k8serrors.NewConflict(v1.Resource("pods"), pod.Name, errors.New("precondition failed: ResourceVersion in precondition does not match ResourceVersion in object"))
+		})
+
+		// act
+		err := client.DeletePod(ctx, pod)
+
+		assert.True(t, errors.Is(err, ErrPreconditionFailed))
+	})
+
+	t.Run("ReturnsConflictAsIsWithoutPrecondition", func(t *testing.T) {
+
+		ctx := context.Background()
+		pod := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "build-estafette-ci-api-1-abcde", Namespace: "estafette-ci-jobs", UID: "1"}}
+		client := getFakeClient()
+		client.precondition = ""
+		client.kubeClientset.(*fake.Clientset).PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, k8serrors.NewConflict(v1.Resource("pods"), pod.Name, errors.New("the object has been modified"))
+		})
+
+		// act
+		err := client.DeletePod(ctx, pod)
+
+		assert.False(t, errors.Is(err, ErrPreconditionFailed))
+		assert.True(t, k8serrors.IsConflict(err))
+	})
+}
+
+func TestLeaderTerms(t *testing.T) {
+	t.Run("WaitsForRunOfEndedTermToReturn", func(t *testing.T) {
+
+		terms := &leaderTerms{}
+		ctx, endTerm := context.WithCancel(context.Background())
+		started := make(chan struct{})
+		returned := false
+		go terms.run(ctx, func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			returned = true
+		})
+		<-started
+		endTerm()
+
+		// act
+		terms.wait()
+
+		assert.True(t, returned)
+	})
+
+	t.Run("DoesNotRunTermThatEndedBeforeItStarted", func(t *testing.T) {
+
+		terms := &leaderTerms{}
+		ctx, endTerm := context.WithCancel(context.Background())
+		endTerm()
+		ran := false
+
+		// act
+		terms.run(ctx, func(ctx context.Context) {
+			ran = true
+		})
+
+		assert.False(t, ran)
+	})
+}
+
+func TestAppendToConfigMap(t *testing.T) {
+	t.Run("CreatesConfigMapWithoutLabelsIfItDoesNotExist", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		err := client.AppendToConfigMap(ctx, "estafette-ci-hanging-job-cleaner-audit", "audit.jsonl", "first", 3)
+
+		assert.Nil(t, err)
+		configmap, err := client.kubeClientset.CoreV1().ConfigMaps("estafette-ci-jobs").Get(ctx, "estafette-ci-hanging-job-cleaner-audit", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "first\n", configmap.Data["audit.jsonl"])
+		assert.Empty(t, configmap.Labels)
+	})
+
+	t.Run("DropsOldestLinesBeyondMaxLines", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		for _, line := range []string{"first", "second", "third", "fourth", "fifth"} {
+			err := client.AppendToConfigMap(ctx, "estafette-ci-hanging-job-cleaner-audit", "audit.jsonl", line, 3)
+			assert.Nil(t, err)
+		}
+
+		configmap, err := client.kubeClientset.CoreV1().ConfigMaps("estafette-ci-jobs").Get(ctx, "estafette-ci-hanging-job-cleaner-audit", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "third\nfourth\nfifth\n", configmap.Data["audit.jsonl"])
+	})
+
+	t.Run("KeepsAllLinesWithoutMaxLines", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "estafette-ci-hanging-job-cleaner-audit", Namespace: "estafette-ci-jobs"},
+			Data:       map[string]string{"audit.jsonl": "first\nsecond\n", "other": "untouched\n"},
+		})
+
+		// act
+		err := client.AppendToConfigMap(ctx, "estafette-ci-hanging-job-cleaner-audit", "audit.jsonl", "third", 0)
+
+		assert.Nil(t, err)
+		configmap, err := client.kubeClientset.CoreV1().ConfigMaps("estafette-ci-jobs").Get(ctx, "estafette-ci-hanging-job-cleaner-audit", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "first\nsecond\nthird\n", configmap.Data["audit.jsonl"])
+		assert.Equal(t, "untouched\n", configmap.Data["other"])
+	})
+}
+
+func TestLastLogLineTime(t *testing.T) {
+	t.Run("ReturnsTimestampOfLastLine", func(t *testing.T) {
+
+		logs := "2022-09-01T10:00:00.123456789Z step one\n2022-09-01T10:05:00.5Z step two\n"
+
+		// act
+		logTime, err := lastLogLineTime(strings.NewReader(logs))
+
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2022, 9, 1, 10, 5, 0, 500000000, time.UTC), *logTime)
+	})
+
+	t.Run("ReturnsNilWithoutLines", func(t *testing.T) {
+
+		// act
+		logTime, err := lastLogLineTime(strings.NewReader(""))
+
+		assert.Nil(t, err)
+		assert.Nil(t, logTime)
+	})
+
+	t.Run("ReadsTimestampOfLinesLongerThanBuffer", func(t *testing.T) {
+
+		logs := "2022-09-01T10:05:00Z " + strings.Repeat("x", 10000) + "\n"
+
+		// act
+		logTime, err := lastLogLineTime(strings.NewReader(logs))
+
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2022, 9, 1, 10, 5, 0, 0, time.UTC), *logTime)
+	})
+
+	t.Run("ReturnsErrorForLinesWithoutTimestamp", func(t *testing.T) {
+
+		// act
+		_, err := lastLogLineTime(strings.NewReader("fake logs"))
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetJobPods(t *testing.T) {
+	t.Run("ReturnsPodsOfJob", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient(
+			getPod("build-estafette-ci-api-1-abcde", map[string]string{"job-name": "build-estafette-ci-api-1"}),
+			getPod("build-estafette-ci-api-2-fghij", map[string]string{"job-name": "build-estafette-ci-api-2"}),
+		)
+
+		// act
+		pods, err := client.GetJobPods(ctx, "build-estafette-ci-api-1")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(pods))
+		assert.Equal(t, "build-estafette-ci-api-1-abcde", pods[0].Name)
+	})
+}
+
+func TestGetNode(t *testing.T) {
+	t.Run("ReturnsNode", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})
+
+		// act
+		node, err := client.GetNode(ctx, "node-1")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "node-1", node.Name)
+	})
+
+	t.Run("ReturnsNilIfNodeIsGone", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		node, err := client.GetNode(ctx, "node-1")
+
+		assert.Nil(t, err)
+		assert.Nil(t, node)
+	})
+}
+
+func TestRemoveFinalizers(t *testing.T) {
+	t.Run("ClearsFinalizersOfJob", func(t *testing.T) {
+
+		ctx := context.Background()
+		job := getJob("build-estafette-ci-api-1")
+		job.Finalizers = []string{"foregroundDeletion"}
+		client := getFakeClient(job)
+
+		// act
+		err := client.RemoveFinalizers(ctx, "job", "build-estafette-ci-api-1")
+
+		assert.Nil(t, err)
+		patchedJob, err := client.GetJob(ctx, "build-estafette-ci-api-1")
+		assert.Nil(t, err)
+		assert.Empty(t, patchedJob.Finalizers)
+	})
+
+	t.Run("IgnoresMissingObject", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		err := client.RemoveFinalizers(ctx, "configmap", "build-estafette-ci-api-1")
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorForUnsupportedKind", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		err := client.RemoveFinalizers(ctx, "deployment", "estafette-ci-api")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSetConfigMapData(t *testing.T) {
+	t.Run("CreatesConfigMapIfItDoesNotExist", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		err := client.SetConfigMapData(ctx, "warnings", map[string]string{"build-1": "2022-09-01T10:00:00Z"})
+
+		assert.Nil(t, err)
+		data, err := client.GetConfigMapData(ctx, "warnings")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"build-1": "2022-09-01T10:00:00Z"}, data)
+	})
+
+	t.Run("ReplacesDataOfExistingConfigMap", func(t *testing.T) {
+
+		ctx := context.Background()
+		configmap := getConfigMap("warnings", nil)
+		configmap.Data = map[string]string{"build-1": "2022-09-01T10:00:00Z"}
+		client := getFakeClient(configmap)
+
+		// act
+		err := client.SetConfigMapData(ctx, "warnings", map[string]string{"release-2": "2022-09-01T11:00:00Z"})
+
+		assert.Nil(t, err)
+		data, err := client.GetConfigMapData(ctx, "warnings")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"release-2": "2022-09-01T11:00:00Z"}, data)
+	})
+}
+
+func TestGetConfigMapData(t *testing.T) {
+	t.Run("ReturnsNilIfConfigMapDoesNotExist", func(t *testing.T) {
+
+		ctx := context.Background()
+		client := getFakeClient()
+
+		// act
+		data, err := client.GetConfigMapData(ctx, "warnings")
+
+		assert.Nil(t, err)
+		assert.Nil(t, data)
+	})
+}
+
+func getFakeClient(objects ...runtime.Object) *client {
+	return &client{
+		kubeClientset: fake.NewSimpleClientset(objects...),
+		namespace:     "estafette-ci-jobs",
+		labelSelector: "createdBy=estafette",
+		pageSize:      1,
+	}
+}
+
+func getConfigMap(name string, labels map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "estafette-ci-jobs",
+			Labels:    labels,
+		},
+	}
+}
+
+func getPod(name string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "estafette-ci-jobs",
+			Labels:    labels,
+		},
+	}
+}
+
+func getJob(name string) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "estafette-ci-jobs",
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package kubernetesapi
+
+import (
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeletePolicy defines how objects of a kind get deleted; an empty propagation policy leaves it to the default of the
+// kind and a nil grace period to the default of the object
+type DeletePolicy struct {
+	PropagationPolicy  string
+	GracePeriodSeconds *int64
+}
+
+const (
+	// PreconditionNone deletes whatever object has the name at the time of deletion
+	PreconditionNone = "none"
+	// PreconditionUID only deletes the object if it hasn't been recreated under the same name
+	PreconditionUID = "uid"
+	// PreconditionResourceVersion only deletes the object if it hasn't been recreated or changed at all
+	PreconditionResourceVersion = "resource-version"
+)
+
+// ErrPreconditionFailed is returned when an object doesn't get deleted because its preconditions show it got recreated or
+// changed since it was evaluated
+var ErrPreconditionFailed = errors.New("object got recreated or changed since it was listed, not deleting it")
+
+// validateDeletePolicies rejects unknown propagation policies, negative grace periods and unknown preconditions
+func validateDeletePolicies(deletePolicies map[string]DeletePolicy, precondition string) error {
+	for kind, policy := range deletePolicies {
+		switch metav1.DeletionPropagation(policy.PropagationPolicy) {
+		case "", metav1.DeletePropagationForeground, metav1.DeletePropagationBackground, metav1.DeletePropagationOrphan:
+		default:
+			return fmt.Errorf("propagation policy %v for %v is invalid, use %v, %v or %v", policy.PropagationPolicy, kind, metav1.DeletePropagationForeground, metav1.DeletePropagationBackground, metav1.DeletePropagationOrphan)
+		}
+		if policy.GracePeriodSeconds != nil && *policy.GracePeriodSeconds < 0 {
+			return fmt.Errorf("grace period of %v seconds for %v is invalid, it can't be negative", *policy.GracePeriodSeconds, kind)
+		}
+	}
+
+	switch precondition {
+	case "", PreconditionNone, PreconditionUID, PreconditionResourceVersion:
+	default:
+		return fmt.Errorf("precondition %v is invalid, use %v, %v or %v", precondition, PreconditionNone, PreconditionUID, PreconditionResourceVersion)
+	}
+
+	return nil
+}
+
+// SetDeletePolicies replaces the delete policies and precondition, keeping the current ones if they're invalid
+func (c *client) SetDeletePolicies(deletePolicies map[string]DeletePolicy, precondition string) (err error) {
+	err = validateDeletePolicies(deletePolicies, precondition)
+	if err != nil {
+		return
+	}
+
+	c.deletePoliciesMutex.Lock()
+	defer c.deletePoliciesMutex.Unlock()
+
+	c.deletePolicies = deletePolicies
+	c.precondition = precondition
+
+	return nil
+}
+
+// deleteOptions returns the options to delete an object of kind with according to its policy, with preconditions on
+// the identity of the object that was evaluated, if enabled
+func (c *client) deleteOptions(kind string, meta metav1.ObjectMeta) (options metav1.DeleteOptions) {
+	c.deletePoliciesMutex.RLock()
+	defer c.deletePoliciesMutex.RUnlock()
+
+	policy := c.deletePolicies[kind]
+	if policy.PropagationPolicy != "" {
+		propagationPolicy := metav1.DeletionPropagation(policy.PropagationPolicy)
+		options.PropagationPolicy = &propagationPolicy
+	}
+	options.GracePeriodSeconds = policy.GracePeriodSeconds
+
+	switch c.precondition {
+	case PreconditionUID:
+		uid := meta.UID
+		options.Preconditions = &metav1.Preconditions{UID: &uid}
+	case PreconditionResourceVersion:
+		uid := meta.UID
+		resourceVersion := meta.ResourceVersion
+		options.Preconditions = &metav1.Preconditions{UID: &uid, ResourceVersion: &resourceVersion}
+	}
+
+	return options
+}
@@ -0,0 +1,125 @@
+package kubernetesapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDeleteOptions(t *testing.T) {
+	t.Run("AppliesPolicyOfKind", func(t *testing.T) {
+
+		gracePeriodSeconds := int64(30)
+		client := &client{
+			deletePolicies: map[string]DeletePolicy{
+				"job":       {PropagationPolicy: "Foreground", GracePeriodSeconds: &gracePeriodSeconds},
+				"configmap": {PropagationPolicy: "Background"},
+			},
+		}
+
+		// act
+		options := client.deleteOptions("job", metav1.ObjectMeta{Name: "build-estafette-ci-api-1"})
+
+		assert.Equal(t, metav1.DeletePropagationForeground, *options.PropagationPolicy)
+		assert.Equal(t, int64(30), *options.GracePeriodSeconds)
+		assert.Nil(t, options.Preconditions)
+	})
+
+	t.Run("LeavesDefaultsForKindWithoutPolicy", func(t *testing.T) {
+
+		client := &client{}
+
+		// act
+		options := client.deleteOptions("secret", metav1.ObjectMeta{Name: "build-estafette-ci-api-1"})
+
+		assert.Nil(t, options.PropagationPolicy)
+		assert.Nil(t, options.GracePeriodSeconds)
+	})
+
+	t.Run("SetsUIDPrecondition", func(t *testing.T) {
+
+		client := &client{precondition: PreconditionUID}
+
+		// act
+		options := client.deleteOptions("job", metav1.ObjectMeta{Name: "build-estafette-ci-api-1", UID: types.UID("abc"), ResourceVersion: "123"})
+
+		assert.Equal(t, types.UID("abc"), *options.Preconditions.UID)
+		assert.Nil(t, options.Preconditions.ResourceVersion)
+	})
+
+	t.Run("SetsUIDAndResourceVersionPrecondition", func(t *testing.T) {
+
+		client := &client{precondition: PreconditionResourceVersion}
+
+		// act
+		options := client.deleteOptions("job", metav1.ObjectMeta{Name: "build-estafette-ci-api-1", UID: types.UID("abc"), ResourceVersion: "123"})
+
+		assert.Equal(t, types.UID("abc"), *options.Preconditions.UID)
+		assert.Equal(t, "123", *options.Preconditions.ResourceVersion)
+	})
+}
+
+func TestValidateDeletePolicies(t *testing.T) {
+	t.Run("ReturnsErrorForUnknownPropagationPolicy", func(t *testing.T) {
+
+		// act
+		err := validateDeletePolicies(map[string]DeletePolicy{"job": {PropagationPolicy: "Sideways"}}, PreconditionNone)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForNegativeGracePeriod", func(t *testing.T) {
+
+		gracePeriodSeconds := int64(-1)
+
+		// act
+		err := validateDeletePolicies(map[string]DeletePolicy{"pod": {GracePeriodSeconds: &gracePeriodSeconds}}, PreconditionNone)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForUnknownPrecondition", func(t *testing.T) {
+
+		// act
+		err := validateDeletePolicies(nil, "name")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("AcceptsValidPolicies", func(t *testing.T) {
+
+		// act
+		err := validateDeletePolicies(map[string]DeletePolicy{"job": {PropagationPolicy: "Orphan"}}, PreconditionResourceVersion)
+
+		assert.Nil(t, err)
+	})
+}
+
+func TestSetDeletePolicies(t *testing.T) {
+	t.Run("ReplacesPoliciesUsedForDeleteOptions", func(t *testing.T) {
+
+		client := getFakeClient()
+
+		// act
+		err := client.SetDeletePolicies(map[string]DeletePolicy{"job": {PropagationPolicy: "Orphan"}}, PreconditionUID)
+
+		assert.Nil(t, err)
+		options := client.deleteOptions("job", metav1.ObjectMeta{UID: "abc"})
+		assert.Equal(t, metav1.DeletePropagationOrphan, *options.PropagationPolicy)
+		assert.NotNil(t, options.Preconditions)
+	})
+
+	t.Run("KeepsCurrentPoliciesIfInvalid", func(t *testing.T) {
+
+		client := getFakeClient()
+		client.deletePolicies = map[string]DeletePolicy{"job": {PropagationPolicy: "Foreground"}}
+
+		// act
+		err := client.SetDeletePolicies(map[string]DeletePolicy{"job": {PropagationPolicy: "Sideways"}}, PreconditionNone)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, metav1.DeletePropagationForeground, *client.deleteOptions("job", metav1.ObjectMeta{}).PropagationPolicy)
+	})
+}
@@ -0,0 +1,38 @@
+package kubernetesapi
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/client-go/tools/metrics"
+)
+
+var (
+	rateLimiterWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubernetes_api_client_rate_limiter_wait_seconds",
+		Help:    "Time requests to the kubernetes api waited for the client-side rate limiter.",
+		Buckets: []float64{0.001, 0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"verb"})
+
+	registerMetricsOnce sync.Once
+)
+
+// rateLimiterLatency feeds the time client-go waits for its rate limiter into rateLimiterWaitSeconds
+type rateLimiterLatency struct{}
+
+func (rateLimiterLatency) Observe(ctx context.Context, verb string, u url.URL, latency time.Duration) {
+	rateLimiterWaitSeconds.WithLabelValues(verb).Observe(latency.Seconds())
+}
+
+// registerMetrics hooks into the client-go metrics, which can only be registered once per process
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		metrics.Register(metrics.RegisterOpts{
+			RateLimiterLatency: rateLimiterLatency{},
+		})
+	})
+}
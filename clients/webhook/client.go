@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("github.com/estafette/estafette-ci-hanging-job-cleaner/clients/webhook")
+
+type Client interface {
+	Send(ctx context.Context, action corev1.CleanupAction) (err error)
+	SetTarget(url string, timeout time.Duration)
+}
+
+// NewClient returns a new webhook.Client, which posts actions as json to url; sending is a no-op when url is empty
+func NewClient(url string, timeout time.Duration) (Client, error) {
+	return &client{
+		url:     url,
+		timeout: timeout,
+		httpClient: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}, nil
+}
+
+type client struct {
+	url        string
+	timeout    time.Duration
+	httpClient *http.Client
+
+	// targetMutex guards url and timeout, which get replaced when the config file changes
+	targetMutex sync.RWMutex
+}
+
+// SetTarget replaces the url actions get posted to and the timeout of posting them
+func (c *client) SetTarget(url string, timeout time.Duration) {
+	c.targetMutex.Lock()
+	defer c.targetMutex.Unlock()
+
+	c.url = url
+	c.timeout = timeout
+}
+
+func (c *client) Send(ctx context.Context, action corev1.CleanupAction) (err error) {
+	ctx, span := tracer.Start(ctx, "webhook.Client:Send")
+	defer func() { tracing.End(span, err) }()
+
+	c.targetMutex.RLock()
+	url, timeout := c.url, c.timeout
+	c.targetMutex.RUnlock()
+
+	if url == "" {
+		return nil
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	span.SetAttributes(
+		attribute.String("kind", action.Kind),
+		attribute.String("action", action.Action),
+	)
+
+	body, err := json.Marshal(action)
+	if err != nil {
+		return
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status code %v", response.StatusCode)
+	}
+
+	log.Debug().Msgf("Sent %v of %v %v%v to webhook", action.Action, action.Kind, action.Pipeline, action.Name)
+
+	return nil
+}
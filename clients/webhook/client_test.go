@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSend(t *testing.T) {
+	t.Run("PostsActionAsJson", func(t *testing.T) {
+
+		var received corev1.CleanupAction
+		var contentType string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+			json.NewDecoder(r.Body).Decode(&received)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		client, err := NewClient(server.URL, time.Minute)
+		assert.Nil(t, err)
+
+		// act
+		err = client.Send(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1", Action: "warn"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "application/json", contentType)
+		assert.Equal(t, "build", received.Kind)
+		assert.Equal(t, "1", received.ID)
+		assert.Equal(t, "warn", received.Action)
+	})
+
+	t.Run("ReturnsErrorIfWebhookFails", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		client, err := NewClient(server.URL, time.Minute)
+		assert.Nil(t, err)
+
+		// act
+		err = client.Send(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1", Action: "warn"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("DoesNothingWithoutUrl", func(t *testing.T) {
+
+		client, err := NewClient("", time.Minute)
+		assert.Nil(t, err)
+
+		// act
+		err = client.Send(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1", Action: "warn"})
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("TimesOutSlowWebhook", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		client, err := NewClient(server.URL, 10*time.Millisecond)
+		assert.Nil(t, err)
+
+		// act
+		err = client.Send(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1", Action: "warn"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("PostsToTargetSetLater", func(t *testing.T) {
+
+		posted := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			posted = true
+		}))
+		defer server.Close()
+
+		client, err := NewClient("", time.Minute)
+		assert.Nil(t, err)
+		client.SetTarget(server.URL, time.Minute)
+
+		// act
+		err = client.Send(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1", Action: "warn"})
+
+		assert.Nil(t, err)
+		assert.True(t, posted)
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/clients/kubernetesapi"
+	cleaner "github.com/estafette/estafette-ci-hanging-job-cleaner/services/cleaner"
+)
+
+// readConfigFile returns the config file, or an empty one if none is set, so all settings fall back to their flags
+func readConfigFile() (file cleaner.ConfigFile, err error) {
+	if *configFile == "" {
+		return
+	}
+
+	return cleaner.ReadConfigFile(*configFile)
+}
+
+// newCleanerConfig combines the flags with the config file; a flag set on the command line or through its environment
+// variable takes precedence over the config file, which takes precedence over the flag default
+func newCleanerConfig(file cleaner.ConfigFile) (config cleaner.Config, err error) {
+	includeFilter, err := cleaner.NewPipelineFilter(stringsSetting("include-pipeline", *includePipelines, file.Include.Pipelines), stringsSetting("include-branch", *includeBranches, file.Include.Branches))
+	if err != nil {
+		return
+	}
+
+	excludeFilter, err := cleaner.NewPipelineFilter(stringsSetting("exclude-pipeline", *excludePipelines, file.Exclude.Pipelines), stringsSetting("exclude-branch", *excludeBranches, file.Exclude.Branches))
+	if err != nil {
+		return
+	}
+
+	return cleaner.Config{
+		BuildRules: []cleaner.StatusRule{
+			statusRule("running", "build-running-max-age", *buildRunningMaxAge, "build-running-age-reference", *buildRunningAgeReference, file.Builds.Running),
+			statusRule("pending", "build-pending-max-age", *buildPendingMaxAge, "build-pending-age-reference", *buildPendingAgeReference, file.Builds.Pending),
+			statusRule("canceling", "build-canceling-grace-period", *buildCancelingGracePeriod, "build-canceling-age-reference", *buildCancelingAgeReference, file.Builds.Canceling),
+		},
+		ReleaseRules: []cleaner.StatusRule{
+			statusRule("running", "release-running-max-age", *releaseRunningMaxAge, "release-running-age-reference", *releaseRunningAgeReference, file.Releases.Running),
+			statusRule("pending", "release-pending-max-age", *releasePendingMaxAge, "release-pending-age-reference", *releasePendingAgeReference, file.Releases.Pending),
+			statusRule("canceling", "release-canceling-max-age", *releaseCancelingMaxAge, "release-canceling-age-reference", *releaseCancelingAgeReference, file.Releases.Canceling),
+		},
+		CycleTimeout:               *cycleTimeout,
+		LogInactivityTimeout:       durationSetting("build-log-inactivity-timeout", *buildLogInactivityTimeout, file.Builds.LogInactivityTimeout),
+		NodeLostGracePeriod:        durationSetting("node-lost-grace-period", *nodeLostGracePeriod, file.NodeLostGracePeriod),
+		StuckTerminatingThreshold:  durationSetting("stuck-terminating-threshold", *stuckTerminatingThreshold, file.StuckTerminating.Threshold),
+		EscalateStuckTerminating:   boolSetting("escalate-stuck-terminating", *escalateStuckTerminating, file.StuckTerminating.Escalate),
+		MarkFailedAfterForceDelete: boolSetting("mark-failed-after-force-delete", *markFailedAfterForceDelete, file.Builds.MarkFailedAfterForceDelete),
+		Include:                    includeFilter,
+		Exclude:                    excludeFilter,
+		WarnBeforeCancel:           boolSetting("warn-before-cancel", *warnBeforeCancel, file.WarnBeforeCancel),
+		WarningConfigMap:           *warningConfigMap,
+		Watch:                      *watch,
+	}, nil
+}
+
+// newDeletePolicies combines the delete flags with the config file, with the same precedence as newCleanerConfig
+func newDeletePolicies(file cleaner.ConfigFile) (deletePolicies map[string]kubernetesapi.DeletePolicy, precondition string) {
+	deletePolicies = map[string]kubernetesapi.DeletePolicy{
+		"job":       deletePolicy("job", *jobPropagationPolicy, *jobGracePeriodSeconds, file.DeletePolicies.Job),
+		"pod":       deletePolicy("pod", *podPropagationPolicy, *podGracePeriodSeconds, file.DeletePolicies.Pod),
+		"configmap": deletePolicy("configmap", *configMapPropagationPolicy, *configMapGracePeriodSeconds, file.DeletePolicies.ConfigMap),
+		"secret":    deletePolicy("secret", *secretPropagationPolicy, *secretGracePeriodSeconds, file.DeletePolicies.Secret),
+	}
+
+	return deletePolicies, stringSetting("delete-precondition", *deletePrecondition, file.DeletePrecondition)
+}
+
+// newWarningWebhook combines the warning webhook flags with the config file, with the same precedence as
+// newCleanerConfig
+func newWarningWebhook(file cleaner.ConfigFile) (url string, timeout time.Duration) {
+	return stringSetting("warning-webhook-url", *warningWebhookURL, file.WarningWebhook.URL), durationSetting("warning-webhook-timeout", *warningWebhookTimeout, file.WarningWebhook.Timeout)
+}
+
+func statusRule(status, maxAgeFlag string, maxAge time.Duration, ageReferenceFlag, ageReference string, file cleaner.StatusRuleConfigFile) cleaner.StatusRule {
+	rule := cleaner.StatusRule{
+		Status:       status,
+		MaxAge:       maxAge,
+		AgeReference: cleaner.AgeReference(ageReference),
+	}
+
+	if file.MaxAge != nil && !isSet(maxAgeFlag) {
+		rule.MaxAge = *file.MaxAge
+	}
+	if file.AgeReference != nil && !isSet(ageReferenceFlag) {
+		rule.AgeReference = *file.AgeReference
+	}
+
+	return rule
+}
+
+func boolSetting(flag string, value bool, fileValue *bool) bool {
+	if fileValue != nil && !isSet(flag) {
+		return *fileValue
+	}
+
+	return value
+}
+
+func stringSetting(flag string, value string, fileValue *string) string {
+	if fileValue != nil && !isSet(flag) {
+		return *fileValue
+	}
+
+	return value
+}
+
+func int64Setting(flag string, value int64, fileValue *int64) int64 {
+	if fileValue != nil && !isSet(flag) {
+		return *fileValue
+	}
+
+	return value
+}
+
+func durationSetting(flag string, value time.Duration, fileValue *time.Duration) time.Duration {
+	if fileValue != nil && !isSet(flag) {
+		return *fileValue
+	}
+
+	return value
+}
+
+func stringsSetting(flag string, values []string, fileValues []string) []string {
+	if fileValues != nil && !isSet(flag) {
+		return fileValues
+	}
+
+	return values
+}
+
+// isSet returns true if a flag is passed on the command line or through its environment variable, the same way kingpin
+// decides whether to apply its default
+func isSet(flag string) bool {
+	if clause := kingpin.CommandLine.GetFlag(flag); clause != nil && clause.HasEnvarValue() {
+		return true
+	}
+
+	parseContext, err := kingpin.CommandLine.ParseContext(os.Args[1:])
+	if err != nil {
+		return false
+	}
+	for _, element := range parseContext.Elements {
+		if clause, ok := element.Clause.(*kingpin.FlagClause); ok && clause.Model().Name == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deletePolicy turns the propagation policy and grace period flags of a kind, combined with the config file, into a
+// delete policy; a negative grace period leaves it to the default of the object
+func deletePolicy(kind, propagationPolicy string, gracePeriodSeconds int64, file cleaner.DeletePolicyConfigFile) kubernetesapi.DeletePolicy {
+	gracePeriodSeconds = int64Setting(kind+"-grace-period-seconds", gracePeriodSeconds, file.GracePeriodSeconds)
+
+	policy := kubernetesapi.DeletePolicy{
+		PropagationPolicy: stringSetting(kind+"-propagation-policy", propagationPolicy, file.PropagationPolicy),
+	}
+	if gracePeriodSeconds >= 0 {
+		policy.GracePeriodSeconds = &gracePeriodSeconds
+	}
+
+	return policy
+}
+
+// readCredentials returns the client id and secret, reading them from their files if set, so they don't show up in the
+// process list or pod spec
+func readCredentials() (id, secret string, err error) {
+	id, err = readSetting("client id", *clientID, *clientIDFile)
+	if err != nil {
+		return
+	}
+
+	secret, err = readSetting("client secret", *clientSecret, *clientSecretFile)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// readSetting returns the trimmed content of path if set, the value otherwise, and fails if both are empty
+func readSetting(name, value, path string) (string, error) {
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%v file %v can't be read: %w", name, path, err)
+		}
+		value = strings.TrimSpace(string(content))
+	}
+
+	if value == "" {
+		return "", fmt.Errorf("%v is empty, set it or the path of a file holding it", name)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/clients/kubernetesapi"
+	cleaner "github.com/estafette/estafette-ci-hanging-job-cleaner/services/cleaner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSet(t *testing.T) {
+	t.Run("ReturnsTrueIfFlagIsOnCommandLine", func(t *testing.T) {
+
+		setArgs(t, "--warn-before-cancel")
+
+		// act
+		set := isSet("warn-before-cancel")
+
+		assert.True(t, set)
+	})
+
+	t.Run("ReturnsTrueIfFlagIsInEnvironment", func(t *testing.T) {
+
+		setArgs(t)
+		t.Setenv("WARN_BEFORE_CANCEL", "true")
+
+		// act
+		set := isSet("warn-before-cancel")
+
+		assert.True(t, set)
+	})
+
+	t.Run("ReturnsFalseIfOnlyOtherFlagsAreSet", func(t *testing.T) {
+
+		setArgs(t, "--escalate-stuck-terminating")
+		t.Setenv("MARK_FAILED_AFTER_FORCE_DELETE", "true")
+
+		// act
+		set := isSet("warn-before-cancel")
+
+		assert.False(t, set)
+	})
+}
+
+func TestBoolSetting(t *testing.T) {
+	t.Run("ReturnsFlagValueIfSetOnCommandLine", func(t *testing.T) {
+
+		setArgs(t, "--no-warn-before-cancel")
+		fileValue := true
+
+		// act
+		value := boolSetting("warn-before-cancel", false, &fileValue)
+
+		assert.False(t, value)
+	})
+
+	t.Run("ReturnsFlagValueIfSetInEnvironment", func(t *testing.T) {
+
+		setArgs(t)
+		t.Setenv("WARN_BEFORE_CANCEL", "false")
+		fileValue := true
+
+		// act
+		value := boolSetting("warn-before-cancel", false, &fileValue)
+
+		assert.False(t, value)
+	})
+
+	t.Run("ReturnsFileValueIfFlagIsNotSet", func(t *testing.T) {
+
+		setArgs(t)
+		fileValue := true
+
+		// act
+		value := boolSetting("warn-before-cancel", false, &fileValue)
+
+		assert.True(t, value)
+	})
+
+	t.Run("ReturnsFlagDefaultIfFlagAndFileValueAreNotSet", func(t *testing.T) {
+
+		setArgs(t)
+
+		// act
+		value := boolSetting("warn-before-cancel", true, nil)
+
+		assert.True(t, value)
+	})
+}
+
+func TestNewDeletePolicies(t *testing.T) {
+	t.Run("ReturnsFileValuesOverFlagDefaults", func(t *testing.T) {
+
+		setArgs(t)
+		propagationPolicy := "Orphan"
+		gracePeriodSeconds := int64(30)
+		precondition := kubernetesapi.PreconditionUID
+		file := cleaner.ConfigFile{
+			DeletePolicies: cleaner.DeletePoliciesConfigFile{
+				Pod: cleaner.DeletePolicyConfigFile{PropagationPolicy: &propagationPolicy, GracePeriodSeconds: &gracePeriodSeconds},
+			},
+			DeletePrecondition: &precondition,
+		}
+
+		// act
+		deletePolicies, deletePrecondition := newDeletePolicies(file)
+
+		assert.Equal(t, "Orphan", deletePolicies["pod"].PropagationPolicy)
+		assert.Equal(t, int64(30), *deletePolicies["pod"].GracePeriodSeconds)
+		assert.Equal(t, *jobPropagationPolicy, deletePolicies["job"].PropagationPolicy)
+		assert.Equal(t, kubernetesapi.PreconditionUID, deletePrecondition)
+	})
+
+	t.Run("ReturnsFlagValuesIfSetInEnvironment", func(t *testing.T) {
+
+		setArgs(t)
+		t.Setenv("POD_PROPAGATION_POLICY", "Background")
+		t.Setenv("DELETE_PRECONDITION", kubernetesapi.PreconditionNone)
+		propagationPolicy := "Orphan"
+		precondition := kubernetesapi.PreconditionUID
+		file := cleaner.ConfigFile{
+			DeletePolicies: cleaner.DeletePoliciesConfigFile{
+				Pod: cleaner.DeletePolicyConfigFile{PropagationPolicy: &propagationPolicy},
+			},
+			DeletePrecondition: &precondition,
+		}
+
+		// act
+		deletePolicies, deletePreconditionValue := newDeletePolicies(file)
+
+		// the flag variables themselves only get their values from kingpin.Parse, which the test doesn't call
+		assert.Equal(t, *podPropagationPolicy, deletePolicies["pod"].PropagationPolicy)
+		assert.Equal(t, *deletePrecondition, deletePreconditionValue)
+	})
+}
+
+func TestNewWarningWebhook(t *testing.T) {
+	t.Run("ReturnsFileValuesIfFlagsAreNotSet", func(t *testing.T) {
+
+		setArgs(t)
+		url := "https://example.com/warnings"
+		timeout := time.Minute
+		file := cleaner.ConfigFile{
+			WarningWebhook: cleaner.WebhookConfigFile{URL: &url, Timeout: &timeout},
+		}
+
+		// act
+		webhookURL, webhookTimeout := newWarningWebhook(file)
+
+		assert.Equal(t, "https://example.com/warnings", webhookURL)
+		assert.Equal(t, time.Minute, webhookTimeout)
+	})
+
+	t.Run("ReturnsFlagValueIfSetOnCommandLine", func(t *testing.T) {
+
+		setArgs(t, "--warning-webhook-url", "https://example.com/flag")
+		url := "https://example.com/file"
+		file := cleaner.ConfigFile{
+			WarningWebhook: cleaner.WebhookConfigFile{URL: &url},
+		}
+
+		// act
+		webhookURL, webhookTimeout := newWarningWebhook(file)
+
+		// the flag variable itself only gets its value from kingpin.Parse, which the test doesn't call
+		assert.Equal(t, *warningWebhookURL, webhookURL)
+		assert.Equal(t, *warningWebhookTimeout, webhookTimeout)
+	})
+}
+
+// setArgs replaces the command line isSet parses for the duration of the test
+func setArgs(t *testing.T, args ...string) {
+	originalArgs := os.Args
+	os.Args = append([]string{"estafette-ci-hanging-job-cleaner"}, args...)
+	t.Cleanup(func() { os.Args = originalArgs })
+}
@@ -0,0 +1,17 @@
+// Package tracing holds what the clients and services share for recording OpenTelemetry spans
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// End records err on the span, if any, and ends it; defer it in a closure so it sees the final value of a named error
+// result
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
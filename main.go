@@ -2,18 +2,33 @@ package main
 
 import (
 	"context"
-	"io"
+	"fmt"
+	"net/http"
+	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/kingpin"
 	estafetteciapi "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/estafetteciapi"
 	"github.com/estafette/estafette-ci-hanging-job-cleaner/clients/kubernetesapi"
+	webhook "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/webhook"
+	admin "github.com/estafette/estafette-ci-hanging-job-cleaner/services/admin"
+	audit "github.com/estafette/estafette-ci-hanging-job-cleaner/services/audit"
 	cleaner "github.com/estafette/estafette-ci-hanging-job-cleaner/services/cleaner"
+	health "github.com/estafette/estafette-ci-hanging-job-cleaner/services/health"
 	foundation "github.com/estafette/estafette-foundation"
-	"github.com/opentracing/opentracing-go"
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
-	"github.com/uber/jaeger-client-go"
-	jaegercfg "github.com/uber/jaeger-client-go/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
@@ -25,11 +40,97 @@ var (
 	buildDate string
 	goVersion = runtime.Version()
 
+	ageReferences       = []string{string(cleaner.AgeReferenceInserted), string(cleaner.AgeReferenceStarted), string(cleaner.AgeReferenceUpdated), string(cleaner.AgeReferenceLastLogActivity)}
+	propagationPolicies = []string{string(metav1.DeletePropagationForeground), string(metav1.DeletePropagationBackground), string(metav1.DeletePropagationOrphan)}
+
 	// params for apiClient
-	apiBaseURL   = kingpin.Flag("api-base-url", "The base url of the estafette-ci-api to communicate with").Envar("API_BASE_URL").Required().String()
-	clientID     = kingpin.Flag("client-id", "The id of the client as configured in Estafette, to securely communicate with the api.").Envar("CLIENT_ID").Required().String()
-	clientSecret = kingpin.Flag("client-secret", "The secret of the client as configured in Estafette, to securely communicate with the api.").Envar("CLIENT_SECRET").Required().String()
-	jobNamespace = kingpin.Flag("job-namespace", "The namespace where estafette build and release jobs are created.").Envar("JOB_NAMESPACE").Required().String()
+	apiBaseURL       = kingpin.Flag("api-base-url", "The base url of the estafette-ci-api to communicate with").Envar("API_BASE_URL").Required().String()
+	clientID         = kingpin.Flag("client-id", "The id of the client as configured in Estafette, to securely communicate with the api.").Envar("CLIENT_ID").String()
+	clientIDFile     = kingpin.Flag("client-id-file", "The path of a file holding the client id, e.g. from a mounted secret; takes precedence over client-id and is re-read on change.").Envar("CLIENT_ID_FILE").String()
+	clientSecret     = kingpin.Flag("client-secret", "The secret of the client as configured in Estafette, to securely communicate with the api.").Envar("CLIENT_SECRET").String()
+	clientSecretFile = kingpin.Flag("client-secret-file", "The path of a file holding the client secret, e.g. from a mounted secret; takes precedence over client-secret and is re-read on change.").Envar("CLIENT_SECRET_FILE").String()
+	apiTimeout       = kingpin.Flag("api-timeout", "The timeout of a single request attempt to the estafette-ci-api.").Default("10s").Envar("API_TIMEOUT").Duration()
+	apiRetries       = kingpin.Flag("api-retries", "The number of attempts for a GET or DELETE request to the estafette-ci-api failing with a connection error, 429 or 5xx.").Default("3").Envar("API_RETRIES").Int()
+	apiBackoff       = kingpin.Flag("api-backoff", "The backoff strategy between attempts: exponential, exponential-jitter, linear or linear-jitter.").Default("exponential-jitter").Envar("API_BACKOFF").Enum("exponential", "exponential-jitter", "linear", "linear-jitter")
+	apiMaxDuration   = kingpin.Flag("api-max-duration", "The maximum total duration of all attempts of a request to the estafette-ci-api.").Default("1m").Envar("API_MAX_DURATION").Duration()
+	apiQPS           = kingpin.Flag("api-qps", "The maximum number of requests per second to the estafette-ci-api, retries included; 0 disables rate limiting.").Default("5").Envar("API_QPS").Float64()
+	apiBurst         = kingpin.Flag("api-burst", "The maximum number of requests to the estafette-ci-api in a burst above api-qps.").Default("10").Envar("API_BURST").Int()
+	kubernetesQPS    = kingpin.Flag("kubernetes-qps", "The maximum number of requests per second to the kubernetes api.").Default("5").Envar("KUBERNETES_QPS").Float32()
+	kubernetesBurst  = kingpin.Flag("kubernetes-burst", "The maximum number of requests to the kubernetes api in a burst above kubernetes-qps.").Default("10").Envar("KUBERNETES_BURST").Int()
+	jobNamespace     = kingpin.Flag("job-namespace", "The namespace where estafette build and release jobs are created.").Envar("JOB_NAMESPACE").Required().String()
+
+	// params for selecting the jobs, configmaps and secrets to clean
+	labelSelector  = kingpin.Flag("label-selector", "The label selector for jobs, configmaps and secrets to clean; can only be empty if required labels are set.").Default("createdBy=estafette").Envar("LABEL_SELECTOR").String()
+	requiredLabels = kingpin.Flag("required-label", "An additional label jobs, configmaps and secrets need to have to get cleaned, e.g. jobType=build; repeatable.").Envar("REQUIRED_LABELS").Strings()
+	fieldSelector  = kingpin.Flag("field-selector", "The field selector for jobs, configmaps and secrets to clean.").Envar("FIELD_SELECTOR").String()
+	listPageSize   = kingpin.Flag("list-page-size", "The number of jobs, configmaps or secrets to retrieve per list call.").Default("500").Envar("LIST_PAGE_SIZE").Int64()
+
+	// params for how jobs, pods, configmaps and secrets get deleted
+	jobPropagationPolicy        = kingpin.Flag("job-propagation-policy", "How the pods of a deleted job get deleted: Foreground, Background or Orphan.").Default("Foreground").Envar("JOB_PROPAGATION_POLICY").Enum(propagationPolicies...)
+	jobGracePeriodSeconds       = kingpin.Flag("job-grace-period-seconds", "The grace period for deleting jobs; -1 uses the default of the job.").Default("-1").Envar("JOB_GRACE_PERIOD_SECONDS").Int64()
+	podPropagationPolicy        = kingpin.Flag("pod-propagation-policy", "The propagation policy for deleting pods: Foreground, Background or Orphan.").Default("Background").Envar("POD_PROPAGATION_POLICY").Enum(propagationPolicies...)
+	podGracePeriodSeconds       = kingpin.Flag("pod-grace-period-seconds", "The grace period for deleting pods; -1 uses the default of the pod.").Default("-1").Envar("POD_GRACE_PERIOD_SECONDS").Int64()
+	configMapPropagationPolicy  = kingpin.Flag("configmap-propagation-policy", "The propagation policy for deleting configmaps: Foreground, Background or Orphan.").Default("Background").Envar("CONFIGMAP_PROPAGATION_POLICY").Enum(propagationPolicies...)
+	configMapGracePeriodSeconds = kingpin.Flag("configmap-grace-period-seconds", "The grace period for deleting configmaps; -1 uses the default of the configmap.").Default("-1").Envar("CONFIGMAP_GRACE_PERIOD_SECONDS").Int64()
+	secretPropagationPolicy     = kingpin.Flag("secret-propagation-policy", "The propagation policy for deleting secrets: Foreground, Background or Orphan.").Default("Background").Envar("SECRET_PROPAGATION_POLICY").Enum(propagationPolicies...)
+	secretGracePeriodSeconds    = kingpin.Flag("secret-grace-period-seconds", "The grace period for deleting secrets; -1 uses the default of the secret.").Default("-1").Envar("SECRET_GRACE_PERIOD_SECONDS").Int64()
+	deletePrecondition          = kingpin.Flag("delete-precondition", "Only delete jobs, pods, configmaps and secrets that weren't recreated under the same name (uid) or changed at all (resource-version) since they were evaluated; none disables.").Default(kubernetesapi.PreconditionNone).Envar("DELETE_PRECONDITION").Enum(kubernetesapi.PreconditionNone, kubernetesapi.PreconditionUID, kubernetesapi.PreconditionResourceVersion)
+
+	// params for the yaml or json file with cleaner rules and filters, see cleaner.ConfigFile for its schema
+	configFile = kingpin.Flag("config", "The path of a yaml or json file with cleaner rules and filters; flags and environment variables take precedence over it. Reloaded on change when running as a daemon.").Envar("CONFIG_FILE").String()
+
+	// params for limiting which pipelines get their builds and releases cleaned, which can be set in a config file as well
+	includePipelines = kingpin.Flag("include-pipeline", "Only clean builds and releases of pipelines matching this source/owner/name glob, e.g. github.com/estafette/*; repeatable.").Envar("INCLUDE_PIPELINES").Strings()
+	includeBranches  = kingpin.Flag("include-branch", "Only clean builds on branches matching this regular expression; repeatable.").Envar("INCLUDE_BRANCHES").Strings()
+	excludePipelines = kingpin.Flag("exclude-pipeline", "Never clean builds and releases of pipelines matching this source/owner/name glob; repeatable.").Envar("EXCLUDE_PIPELINES").Strings()
+	excludeBranches  = kingpin.Flag("exclude-branch", "Never clean builds on branches matching this regular expression; repeatable.").Envar("EXCLUDE_BRANCHES").Strings()
+
+	// params for the audit trail of every action taken
+	auditFile          = kingpin.Flag("audit-file", "The path of the jsonl file to append every action taken to; disabled when empty.").Envar("AUDIT_FILE").String()
+	auditConfigMap     = kingpin.Flag("audit-configmap", "The name of the configmap in the job namespace to keep the most recent actions taken in; disabled when empty.").Envar("AUDIT_CONFIGMAP").String()
+	auditConfigMapSize = kingpin.Flag("audit-configmap-size", "The number of most recent actions to keep in the audit configmap.").Default("500").Envar("AUDIT_CONFIGMAP_SIZE").Int()
+
+	// params for warning about builds and releases a cycle before canceling them
+	warnBeforeCancel      = kingpin.Flag("warn-before-cancel", "Warn about builds and releases exceeding their max age by annotating their job and calling the warning webhook, and only cancel them if they still exceed it in the next cycle; jobs annotated with estafette.io/cleaner-protect=true never get canceled.").Default("false").Envar("WARN_BEFORE_CANCEL").Bool()
+	warningConfigMap      = kingpin.Flag("warning-configmap", "The name of the configmap in the job namespace to keep warnings in between cycles, so they survive restarts; when empty warnings are kept in memory only, which requires an interval.").Default("estafette-ci-hanging-job-cleaner-warnings").Envar("WARNING_CONFIGMAP").String()
+	warningWebhookURL     = kingpin.Flag("warning-webhook-url", "The url to post a json cleanup action to for every warning; disabled when empty.").Envar("WARNING_WEBHOOK_URL").String()
+	warningWebhookTimeout = kingpin.Flag("warning-webhook-timeout", "The timeout of a request to the warning webhook.").Default("10s").Envar("WARNING_WEBHOOK_TIMEOUT").Duration()
+
+	// params for tracing
+	tracingExporter = kingpin.Flag("tracing-exporter", "The exporter for traces: otlp, stdout for local runs, or none.").Default("otlp").Envar("TRACING_EXPORTER").Enum("otlp", "stdout", "none")
+
+	// params for running as a daemon with multiple replicas
+	interval               = kingpin.Flag("interval", "The time between cleanup cycles; 0 runs a single cycle and exits.").Default("0s").Envar("INTERVAL").Duration()
+	cycleTimeout           = kingpin.Flag("cycle-timeout", "The maximum duration of a cleanup cycle, after which in-flight requests get aborted; 0 disables.").Default("0s").Envar("CYCLE_TIMEOUT").Duration()
+	leaderElection         = kingpin.Flag("leader-election", "Only clean while holding a kubernetes lease, so multiple replicas can run.").Default("false").Envar("LEADER_ELECTION").Bool()
+	leaseName              = kingpin.Flag("lease-name", "The name of the lease used for leader election.").Default("estafette-ci-hanging-job-cleaner").Envar("LEASE_NAME").String()
+	leaseNamespace         = kingpin.Flag("lease-namespace", "The namespace of the lease used for leader election; defaults to the job namespace.").Envar("LEASE_NAMESPACE").String()
+	watch                  = kingpin.Flag("watch", "Clean jobs, pods, configmaps and secrets the moment they exceed their max age using informers, instead of listing them every cycle; requires an interval.").Default("false").Envar("WATCH").Bool()
+	httpPort               = kingpin.Flag("http-port", "The port to serve /healthz, /readyz, /metrics and the admin api on when running as a daemon.").Default("5000").Envar("HTTP_PORT").Int()
+	livenessMultiplier     = kingpin.Flag("liveness-multiplier", "The number of intervals without a completed cycle after which /healthz fails.").Default("3").Envar("LIVENESS_MULTIPLIER").Int()
+	adminToken             = kingpin.Flag("admin-token", "The bearer token to authenticate against the admin api with; the admin api is disabled when empty.").Envar("ADMIN_TOKEN").String()
+	leaderElectionIdentity = kingpin.Flag("leader-election-identity", "The identity of this replica in leader election; defaults to the hostname.").Envar("POD_NAME").String()
+
+	// params for cleaner rules, which can be set in a config file as well; the defaults cancel builds and releases close to 6 hours old (max lifetime of their jwt and last chance to send their logs to the api)
+	buildRunningMaxAge         = kingpin.Flag("build-running-max-age", "The age after which running builds get canceled; 0 disables.").Default("355m").Envar("BUILD_RUNNING_MAX_AGE").Duration()
+	buildPendingMaxAge         = kingpin.Flag("build-pending-max-age", "The age after which pending builds get canceled; 0 disables.").Default("355m").Envar("BUILD_PENDING_MAX_AGE").Duration()
+	buildCancelingGracePeriod  = kingpin.Flag("build-canceling-grace-period", "The time since their last update after which builds stuck in canceling get their job force-deleted; 0 disables.").Default("15m").Envar("BUILD_CANCELING_GRACE_PERIOD").Duration()
+	buildLogInactivityTimeout  = kingpin.Flag("build-log-inactivity-timeout", "The time after which running builds within their max age get canceled if their job pod logged nothing; 0 disables.").Default("0s").Envar("BUILD_LOG_INACTIVITY_TIMEOUT").Duration()
+	nodeLostGracePeriod        = kingpin.Flag("node-lost-grace-period", "The time after which running builds and releases with a job pod on a NotReady or deleted node get their job force-deleted and get canceled; 0 disables.").Default("0s").Envar("NODE_LOST_GRACE_PERIOD").Duration()
+	stuckTerminatingThreshold  = kingpin.Flag("stuck-terminating-threshold", "The time after which jobs, pods, configmaps and secrets that are still terminating count as stuck and show up in reports; 0 disables.").Default("15m").Envar("STUCK_TERMINATING_THRESHOLD").Duration()
+	escalateStuckTerminating   = kingpin.Flag("escalate-stuck-terminating", "Force delete the pods of jobs stuck in terminating and, once still stuck after twice the threshold, remove the finalizers of jobs, pods, configmaps and secrets.").Default("false").Envar("ESCALATE_STUCK_TERMINATING").Bool()
+	markFailedAfterForceDelete = kingpin.Flag("mark-failed-after-force-delete", "Mark builds stuck in canceling as failed after force-deleting their job, if the api supports it.").Default("false").Envar("MARK_FAILED_AFTER_FORCE_DELETE").Bool()
+	releaseRunningMaxAge       = kingpin.Flag("release-running-max-age", "The age after which running releases get canceled; 0 disables.").Default("355m").Envar("RELEASE_RUNNING_MAX_AGE").Duration()
+	releasePendingMaxAge       = kingpin.Flag("release-pending-max-age", "The age after which pending releases get canceled; 0 disables.").Default("355m").Envar("RELEASE_PENDING_MAX_AGE").Duration()
+	releaseCancelingMaxAge     = kingpin.Flag("release-canceling-max-age", "The age after which releases stuck in canceling get canceled again; 0 disables.").Default("355m").Envar("RELEASE_CANCELING_MAX_AGE").Duration()
+
+	// params for the timestamp ages are measured from; started matches the jwt lifetime of running builds and releases
+	buildRunningAgeReference     = kingpin.Flag("build-running-age-reference", "The timestamp the age of running builds is measured from: inserted, started, updated or last-log-activity.").Default(string(cleaner.AgeReferenceStarted)).Envar("BUILD_RUNNING_AGE_REFERENCE").Enum(ageReferences...)
+	buildPendingAgeReference     = kingpin.Flag("build-pending-age-reference", "The timestamp the age of pending builds is measured from: inserted, started, updated or last-log-activity.").Default(string(cleaner.AgeReferenceInserted)).Envar("BUILD_PENDING_AGE_REFERENCE").Enum(ageReferences...)
+	buildCancelingAgeReference   = kingpin.Flag("build-canceling-age-reference", "The timestamp the grace period of canceling builds is measured from: inserted, started, updated or last-log-activity.").Default(string(cleaner.AgeReferenceUpdated)).Envar("BUILD_CANCELING_AGE_REFERENCE").Enum(ageReferences...)
+	releaseRunningAgeReference   = kingpin.Flag("release-running-age-reference", "The timestamp the age of running releases is measured from: inserted, started, updated or last-log-activity.").Default(string(cleaner.AgeReferenceStarted)).Envar("RELEASE_RUNNING_AGE_REFERENCE").Enum(ageReferences...)
+	releasePendingAgeReference   = kingpin.Flag("release-pending-age-reference", "The timestamp the age of pending releases is measured from: inserted, started, updated or last-log-activity.").Default(string(cleaner.AgeReferenceInserted)).Envar("RELEASE_PENDING_AGE_REFERENCE").Enum(ageReferences...)
+	releaseCancelingAgeReference = kingpin.Flag("release-canceling-age-reference", "The timestamp the age of canceling releases is measured from: inserted, started, updated or last-log-activity.").Default(string(cleaner.AgeReferenceUpdated)).Envar("RELEASE_CANCELING_AGE_REFERENCE").Enum(ageReferences...)
 )
 
 func main() {
@@ -40,62 +141,288 @@ func main() {
 	// init log format from envvar ESTAFETTE_LOG_FORMAT
 	foundation.InitLoggingFromEnv(foundation.NewApplicationInfo(appgroup, app, version, branch, revision, buildDate))
 
-	closer := initJaeger(app)
-	defer closer.Close()
+	if *watch && *interval == 0 {
+		log.Fatal().Msg("Watch mode requires an interval for cleaning builds and releases")
+	}
+
+	shutdownTracing := initTracing(context.Background(), app)
+	defer shutdownTracing()
 
-	ctx := context.Background()
+	// cancel the context on sigterm, so a leader can hand off its lease
+	ctx := foundation.InitCancellationContext(context.Background())
 
-	span, ctx := opentracing.StartSpanFromContext(ctx, "main")
-	defer span.Finish()
+	ctx, span := otel.Tracer(app).Start(ctx, "main")
+	defer span.End()
 
-	estafetteciapiClient, err := estafetteciapi.NewClient(*apiBaseURL, *clientID, *clientSecret)
+	id, secret, err := readCredentials()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed reading client credentials")
+	}
+
+	estafetteciapiClient, err := estafetteciapi.NewClient(*apiBaseURL, id, secret, *apiTimeout, *apiRetries, *apiBackoff, *apiMaxDuration, *apiQPS, *apiBurst)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed creating estafetteciapi.Client")
 	}
 
-	kubernetesapiClient, err := kubernetesapi.NewClient(*jobNamespace)
+	// combine the label selector with the required labels, skipping any that are empty
+	selectorParts := []string{}
+	for _, part := range append([]string{*labelSelector}, *requiredLabels...) {
+		if strings.TrimSpace(part) != "" {
+			selectorParts = append(selectorParts, strings.TrimSpace(part))
+		}
+	}
+
+	file, err := readConfigFile()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed reading config file")
+	}
+
+	deletePolicies, precondition := newDeletePolicies(file)
+	kubernetesapiClient, err := kubernetesapi.NewClient(*jobNamespace, strings.Join(selectorParts, ","), *fieldSelector, *listPageSize, *kubernetesQPS, *kubernetesBurst, deletePolicies, precondition)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed creating kubernetesapi.Client")
 	}
 
-	cleanerService, err := cleaner.NewService(estafetteciapiClient, kubernetesapiClient)
+	cleanerConfig, err := newCleanerConfig(file)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed creating cleaner config")
+	}
+
+	// without the configmap a single cycle run would forget its warnings and never cancel anything
+	if cleanerConfig.WarnBeforeCancel && cleanerConfig.WarningConfigMap == "" && *interval == 0 {
+		log.Fatal().Msg("Warning before canceling requires a warning configmap or an interval")
+	}
+
+	auditService, err := audit.NewService(kubernetesapiClient, *auditFile, *auditConfigMap, *auditConfigMapSize)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed creating audit.Service")
+	}
+
+	webhookClient, err := webhook.NewClient(newWarningWebhook(file))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed creating webhook.Client")
+	}
+
+	cleanerService, err := cleaner.NewService(estafetteciapiClient, kubernetesapiClient, webhookClient, auditService, cleanerConfig)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed creating cleaner.Service")
 	}
 
+	// pick up rotated credentials, e.g. from a mounted secret, on the next login without restarting
+	if *interval > 0 {
+		for _, path := range []string{*clientIDFile, *clientSecretFile} {
+			if path == "" {
+				continue
+			}
+			foundation.WatchForFileChanges(path, func(event fsnotify.Event) {
+				log.Info().Msgf("Credentials file %v changed, reloading...", event.Name)
+				id, secret, err := readCredentials()
+				if err != nil {
+					log.Error().Err(err).Msg("Failed reloading client credentials, keeping the current ones")
+					return
+				}
+				estafetteciapiClient.SetCredentials(id, secret)
+			})
+		}
+	}
+
+	// pick up changes to rules, filters, delete policies and the warning webhook, e.g. from a mounted configmap, without
+	// restarting
+	if *configFile != "" && *interval > 0 {
+		foundation.WatchForFileChanges(*configFile, func(event fsnotify.Event) {
+			log.Info().Msgf("Config file %v changed, reloading...", *configFile)
+			file, err := readConfigFile()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed reloading config file, keeping the current config")
+				return
+			}
+			cleanerConfig, err := newCleanerConfig(file)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed reloading config file, keeping the current config")
+				return
+			}
+			err = kubernetesapiClient.SetDeletePolicies(newDeletePolicies(file))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed reloading config file, keeping the current config")
+				return
+			}
+			webhookClient.SetTarget(newWarningWebhook(file))
+			cleanerService.SetConfig(cleanerConfig)
+		})
+	}
+
+	healthService, err := health.NewService(*interval, *livenessMultiplier)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed creating health.Service")
+	}
+
+	if *interval > 0 {
+		mux := http.NewServeMux()
+		healthService.RegisterHandlers(mux)
+		mux.Handle("/metrics", promhttp.Handler())
+
+		if *adminToken != "" {
+			adminService, err := admin.NewService(ctx, cleanerService, *adminToken, healthService.IsLeading)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed creating admin.Service")
+			}
+			adminService.RegisterHandlers(mux)
+		}
+
+		go serveHTTP(mux)
+	}
+
 	err = cleanerService.Init(ctx)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed initializing cleaner service")
 	}
+	healthService.SetReady(true)
+
+	if !*leaderElection {
+		run(ctx, cleanerService, healthService)
+		return
+	}
+
+	identity := *leaderElectionIdentity
+	if identity == "" {
+		identity, err = os.Hostname()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed retrieving hostname as leader election identity")
+		}
+	}
+
+	namespace := *leaseNamespace
+	if namespace == "" {
+		namespace = *jobNamespace
+	}
+
+	healthService.SetLeading(false)
+
+	leaderCtx, cancelLeaderElection := context.WithCancel(ctx)
+	defer cancelLeaderElection()
 
-	err = cleanerService.Clean(ctx)
+	err = kubernetesapiClient.RunWithLeaderElection(leaderCtx, *leaseName, namespace, identity, func(ctx context.Context) {
+		healthService.SetLeading(true)
+		defer healthService.SetLeading(false)
+
+		run(ctx, cleanerService, healthService)
+
+		// in single run mode there's nothing left to lead, release the lease
+		if *interval == 0 {
+			cancelLeaderElection()
+		}
+	})
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed cleaning builds and releases")
+		log.Fatal().Err(err).Msg("Failed running leader election")
+	}
+}
+
+// run starts watching kubernetes objects in watch mode and runs the cleanup cycles until ctx is done
+func run(ctx context.Context, cleanerService cleaner.Service, healthService health.Service) {
+	if *watch {
+		go func() {
+			err := cleanerService.Watch(ctx)
+			if err != nil && ctx.Err() == nil {
+				// cycles leave jobs, pods, configmaps and secrets to the watch, so nothing would clean them anymore
+				log.Fatal().Err(err).Msg("Failed watching jobs, pods, configmaps and secrets")
+			}
+		}()
 	}
 
-	log.Info().Msg("Done!")
+	runCycles(ctx, cleanerService, healthService)
 }
 
-func handleError(jaegerCloser io.Closer, err error, message string) {
-	if err != nil {
-		jaegerCloser.Close()
-		log.Fatal().Err(err).Msg(message)
+// runCycles cleans once, or with an interval set keeps cleaning until ctx is done
+func runCycles(ctx context.Context, cleanerService cleaner.Service, healthService health.Service) {
+	for {
+		report, err := cleanerService.Clean(ctx, false)
+		if err != nil {
+			if *interval == 0 {
+				log.Fatal().Err(err).Msg("Failed cleaning builds and releases")
+			}
+			log.Error().Err(err).Msg("Failed cleaning builds and releases")
+		} else {
+			log.Info().Msgf("Done! Took %v actions in %v", len(report.Actions), report.FinishedAt.Sub(report.StartedAt))
+			healthService.CycleCompleted()
+		}
+
+		if *interval == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*interval):
+		}
+
+		// refresh the token, it's short-lived compared to a daemon's lifetime
+		err = cleanerService.Init(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed initializing cleaner service")
+		}
+		healthService.SetReady(err == nil)
 	}
 }
 
-// initJaeger returns an instance of Jaeger Tracer that can be configured with environment variables
-// https://github.com/jaegertracing/jaeger-client-go#environment-variables
-func initJaeger(service string) io.Closer {
+func serveHTTP(handler http.Handler) {
+	portString := fmt.Sprintf(":%v", *httpPort)
+	log.Debug().
+		Str("port", portString).
+		Msg("Serving http endpoints...")
 
-	cfg, err := jaegercfg.FromEnv()
+	if err := http.ListenAndServe(portString, handler); err != nil {
+		log.Fatal().Err(err).Msg("Starting http listener failed")
+	}
+}
+
+// initTracing sets up the global OpenTelemetry tracer provider with the exporter set by --tracing-exporter; the otlp
+// exporter, sampler and resource can be configured with the standard environment variables
+// https://opentelemetry.io/docs/reference/specification/sdk-environment-variables/
+func initTracing(ctx context.Context, service string) (shutdown func()) {
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch *tracingExporter {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		// the global tracer provider is a no-op until set
+		return func() {}
+	}
 	if err != nil {
-		log.Fatal().Err(err).Msg("Generating Jaeger config from environment variables failed")
+		log.Fatal().Err(err).Msgf("Creating %v trace exporter failed", *tracingExporter)
 	}
 
-	closer, err := cfg.InitGlobalTracer(service, jaegercfg.Logger(jaeger.StdLogger))
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceNameKey.String(service),
+			semconv.ServiceVersionKey.String(version),
+		),
+	)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Generating Jaeger tracer failed")
+		log.Fatal().Err(err).Msg("Creating trace resource failed")
 	}
 
-	return closer
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func() {
+		// flush pending spans, without hanging on an unreachable collector
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := tracerProvider.Shutdown(ctx)
+		if err != nil {
+			log.Warn().Err(err).Msg("Flushing spans failed")
+		}
+	}
 }
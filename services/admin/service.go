@@ -0,0 +1,137 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	cleaner "github.com/estafette/estafette-ci-hanging-job-cleaner/services/cleaner"
+	"github.com/rs/zerolog/log"
+)
+
+type Service interface {
+	RegisterHandlers(mux *http.ServeMux)
+}
+
+// NewService returns a new admin.Service, which only serves requests carrying the token as bearer token and
+// refuses to trigger cycles while isLeading returns false; cycles run with ctx rather than the request's context, so a
+// client disconnecting doesn't abort a cycle halfway
+func NewService(ctx context.Context, cleanerService cleaner.Service, token string, isLeading func() bool) (Service, error) {
+	return &service{
+		ctx:            ctx,
+		cleanerService: cleanerService,
+		token:          token,
+		isLeading:      isLeading,
+	}, nil
+}
+
+type service struct {
+	ctx            context.Context
+	cleanerService cleaner.Service
+	token          string
+	isLeading      func() bool
+}
+
+func (s *service) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/cycles", s.authenticate(s.postCycle))
+	mux.HandleFunc("/cycles/latest", s.authenticate(s.getLatestCycle))
+	mux.HandleFunc("/candidates", s.authenticate(s.getCandidates))
+}
+
+// POST /cycles?dryRun=true
+func (s *service) postCycle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.isLeading() {
+		http.Error(w, "This replica is not the leader", http.StatusConflict)
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dryRun"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Query parameter dryRun is not a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+
+	log.Info().Msgf("Running cleanup cycle triggered through admin api with dry run %v...", dryRun)
+
+	report, err := s.cleanerService.Clean(s.ctx, dryRun)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed cleanup cycle triggered through admin api")
+		writeJSON(w, http.StatusInternalServerError, struct {
+			Error  string      `json:"error"`
+			Report interface{} `json:"report"`
+		}{
+			Error:  err.Error(),
+			Report: report,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// GET /cycles/latest
+func (s *service) getLatestCycle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := s.cleanerService.LatestReport()
+	if report == nil {
+		http.Error(w, "No cycle has run yet", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// GET /candidates
+func (s *service) getCandidates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := s.cleanerService.Candidates(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed retrieving cleanup candidates through admin api")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report.Actions)
+}
+
+func (s *service) authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if s.token == "" || !strings.HasPrefix(header, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(s.token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed writing json response")
+	}
+}
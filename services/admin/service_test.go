@@ -0,0 +1,239 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	cleaner "github.com/estafette/estafette-ci-hanging-job-cleaner/services/cleaner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	t.Run("ReturnsUnauthorizedWithoutToken", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodGet, "/cycles/latest", "")
+
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+
+	t.Run("ReturnsUnauthorizedWithWrongToken", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodGet, "/cycles/latest", "Bearer wrong")
+
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+
+	t.Run("ReturnsUnauthorizedWithTokenWithoutBearerScheme", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodGet, "/cycles/latest", "secret")
+
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+
+	t.Run("ReturnsUnauthorizedIfNoTokenIsConfigured", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodGet, "/cycles/latest", "Bearer ")
+
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+
+	t.Run("ServesRequestWithBearerToken", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{latestReport: &corev1.CycleReport{}}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodGet, "/cycles/latest", "Bearer secret")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestPostCycle(t *testing.T) {
+	t.Run("ReturnsMethodNotAllowedForGet", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodGet, "/cycles", "Bearer secret")
+
+		assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+	})
+
+	t.Run("ReturnsConflictIfNotLeading", func(t *testing.T) {
+
+		cleaned := false
+		server := getTestServer(&fakeCleanerService{
+			clean: func(ctx context.Context, dryRun bool) (corev1.CycleReport, error) {
+				cleaned = true
+				return corev1.CycleReport{}, nil
+			},
+		}, "secret", false)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodPost, "/cycles", "Bearer secret")
+
+		assert.Equal(t, http.StatusConflict, response.StatusCode)
+		assert.False(t, cleaned)
+	})
+
+	t.Run("ReturnsBadRequestForInvalidDryRun", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodPost, "/cycles?dryRun=maybe", "Bearer secret")
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("RunsCycleWithDryRun", func(t *testing.T) {
+
+		var cleanedWithDryRun bool
+		server := getTestServer(&fakeCleanerService{
+			clean: func(ctx context.Context, dryRun bool) (corev1.CycleReport, error) {
+				cleanedWithDryRun = dryRun
+				return corev1.CycleReport{DryRun: dryRun}, nil
+			},
+		}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodPost, "/cycles?dryRun=true", "Bearer secret")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.True(t, cleanedWithDryRun)
+	})
+
+	t.Run("ReturnsInternalServerErrorIfCycleFails", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{
+			clean: func(ctx context.Context, dryRun bool) (corev1.CycleReport, error) {
+				return corev1.CycleReport{}, errors.New("api unavailable")
+			},
+		}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodPost, "/cycles", "Bearer secret")
+
+		assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	})
+}
+
+func TestGetLatestCycle(t *testing.T) {
+	t.Run("ReturnsNotFoundBeforeFirstCycle", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodGet, "/cycles/latest", "Bearer secret")
+
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("ReturnsMethodNotAllowedForPost", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodPost, "/cycles/latest", "Bearer secret")
+
+		assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+	})
+}
+
+func TestGetCandidates(t *testing.T) {
+	t.Run("ReturnsMethodNotAllowedForPost", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{}, "secret", true)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodPost, "/candidates", "Bearer secret")
+
+		assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+	})
+
+	t.Run("ReturnsCandidatesWhenNotLeading", func(t *testing.T) {
+
+		server := getTestServer(&fakeCleanerService{
+			candidates: func(ctx context.Context) (corev1.CycleReport, error) {
+				return corev1.CycleReport{DryRun: true}, nil
+			},
+		}, "secret", false)
+		defer server.Close()
+
+		// act
+		response := request(t, server, http.MethodGet, "/candidates", "Bearer secret")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func getTestServer(cleanerService cleaner.Service, token string, leading bool) *httptest.Server {
+	adminService, _ := NewService(context.Background(), cleanerService, token, func() bool { return leading })
+	mux := http.NewServeMux()
+	adminService.RegisterHandlers(mux)
+
+	return httptest.NewServer(mux)
+}
+
+func request(t *testing.T, server *httptest.Server, method, path, authorization string) *http.Response {
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	assert.Nil(t, err)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	response, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	response.Body.Close()
+
+	return response
+}
+
+// fakeCleanerService only implements the methods a test sets, calling any other method panics
+type fakeCleanerService struct {
+	cleaner.Service
+	clean        func(ctx context.Context, dryRun bool) (corev1.CycleReport, error)
+	candidates   func(ctx context.Context) (corev1.CycleReport, error)
+	latestReport *corev1.CycleReport
+}
+
+func (s *fakeCleanerService) Clean(ctx context.Context, dryRun bool) (corev1.CycleReport, error) {
+	return s.clean(ctx, dryRun)
+}
+
+func (s *fakeCleanerService) Candidates(ctx context.Context) (corev1.CycleReport, error) {
+	return s.candidates(ctx)
+}
+
+func (s *fakeCleanerService) LatestReport() *corev1.CycleReport {
+	return s.latestReport
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	kubernetesapi "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/kubernetesapi"
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/estafette/estafette-ci-hanging-job-cleaner/services/audit")
+
+type Service interface {
+	Record(ctx context.Context, action corev1.CleanupAction) (err error)
+}
+
+// NewService returns a new audit.Service, appending to the jsonl file at filePath and to the configmap ring buffer
+// named configMapName of configMapSize entries; either sink is disabled when its path or name is empty
+func NewService(kubernetesapiClient kubernetesapi.Client, filePath, configMapName string, configMapSize int) (Service, error) {
+	return &service{
+		kubernetesapiClient: kubernetesapiClient,
+		filePath:            filePath,
+		configMapName:       configMapName,
+		configMapSize:       configMapSize,
+	}, nil
+}
+
+type service struct {
+	kubernetesapiClient kubernetesapi.Client
+	filePath            string
+	configMapName       string
+	configMapSize       int
+	fileMutex           sync.Mutex
+}
+
+func (s *service) Record(ctx context.Context, action corev1.CleanupAction) (err error) {
+	ctx, span := tracer.Start(ctx, "audit.Service:Record")
+	defer func() { tracing.End(span, err) }()
+
+	if s.filePath == "" && s.configMapName == "" {
+		return nil
+	}
+
+	bytes, err := json.Marshal(action)
+	if err != nil {
+		return
+	}
+
+	if s.filePath != "" {
+		err = s.appendToFile(bytes)
+		if err != nil {
+			return
+		}
+	}
+
+	if s.configMapName != "" {
+		err = s.kubernetesapiClient.AppendToConfigMap(ctx, s.configMapName, "audit.jsonl", string(bytes), s.configMapSize)
+		if err != nil {
+			return
+		}
+	}
+
+	log.Debug().Str("entry", string(bytes)).Msg("Recorded audit entry")
+
+	return nil
+}
+
+func (s *service) appendToFile(bytes []byte) (err error) {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+
+	file, err := os.OpenFile(s.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(bytes, '\n'))
+	if err != nil {
+		return
+	}
+
+	return file.Sync()
+}
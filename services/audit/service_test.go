@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	t.Run("AppendsOneJsonObjectPerLineToFile", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		service, err := NewService(nil, path, "", 0)
+		assert.Nil(t, err)
+
+		// act
+		err = service.Record(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1", Age: 3 * time.Hour, MaxAge: 2 * time.Hour, Action: "cancel"})
+		assert.Nil(t, err)
+		err = service.Record(context.Background(), corev1.CleanupAction{Kind: "release", ID: "2", Age: 45 * time.Minute, MaxAge: 30 * time.Minute, Action: "cancel"})
+		assert.Nil(t, err)
+
+		content, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		if assert.Equal(t, 2, len(lines)) {
+			var first, second map[string]interface{}
+			assert.Nil(t, json.Unmarshal([]byte(lines[0]), &first))
+			assert.Nil(t, json.Unmarshal([]byte(lines[1]), &second))
+			assert.Equal(t, "build", first["kind"])
+			assert.Equal(t, "3h0m0s", first["age"])
+			assert.Equal(t, "2h0m0s", first["maxAge"])
+			assert.Equal(t, "release", second["kind"])
+			assert.Equal(t, "45m0s", second["age"])
+		}
+	})
+
+	t.Run("AppendsToExistingFile", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		err := ioutil.WriteFile(path, []byte("{\"kind\":\"build\",\"id\":\"0\"}\n"), 0644)
+		assert.Nil(t, err)
+		service, err := NewService(nil, path, "", 0)
+		assert.Nil(t, err)
+
+		// act
+		err = service.Record(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1"})
+
+		assert.Nil(t, err)
+		content, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		if assert.Equal(t, 2, len(lines)) {
+			assert.Equal(t, "{\"kind\":\"build\",\"id\":\"0\"}", lines[0])
+			var action corev1.CleanupAction
+			assert.Nil(t, json.Unmarshal([]byte(lines[1]), &action))
+			assert.Equal(t, "1", action.ID)
+		}
+	})
+
+	t.Run("ReturnsErrorIfFileCantBeOpened", func(t *testing.T) {
+
+		service, err := NewService(nil, filepath.Join(t.TempDir(), "missing", "audit.jsonl"), "", 0)
+		assert.Nil(t, err)
+
+		// act
+		err = service.Record(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("DoesNothingWithoutSinks", func(t *testing.T) {
+
+		service, err := NewService(nil, "", "", 0)
+		assert.Nil(t, err)
+
+		// act
+		err = service.Record(context.Background(), corev1.CleanupAction{Kind: "build", ID: "1"})
+
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,234 @@
+package cleaner
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// kubernetesObjectMaxAge is the age after which jobs, configmaps and secrets missed being cleaned up by the api, a bit
+// beyond the max lifetime of the jwt of their build or release
+const kubernetesObjectMaxAge = time.Duration(6*60+5) * time.Minute
+
+// Config holds the rules the cleaner applies to builds and releases
+type Config struct {
+	BuildRules   []StatusRule
+	ReleaseRules []StatusRule
+
+	// CycleTimeout aborts in-flight requests of a cycle, whether it runs on the interval or through the admin api, once
+	// it exceeds this duration; 0 disables it
+	CycleTimeout time.Duration
+
+	// LogInactivityTimeout cancels running builds within their max age whose job pod logged nothing for this long; 0
+	// disables it
+	LogInactivityTimeout time.Duration
+
+	// NodeLostGracePeriod force-deletes the job of running builds and releases and cancels them once a node their pod
+	// is bound to has been NotReady or gone for this long; 0 disables it
+	NodeLostGracePeriod time.Duration
+
+	// MarkFailedAfterForceDelete marks builds as failed in the api after their job got force-deleted for hanging in canceling
+	MarkFailedAfterForceDelete bool
+
+	// Include limits cleaning builds and releases to matching pipelines, if set; Exclude never cleans matching pipelines
+	Include PipelineFilter
+	Exclude PipelineFilter
+
+	// WarnBeforeCancel warns about builds and releases exceeding their max age one cycle before canceling them, and
+	// never cancels those whose job is annotated as protected
+	WarnBeforeCancel bool
+
+	// WarningConfigMap is the name of the configmap the warnings get stored in between cycles; when empty they're only
+	// kept in memory, which doesn't survive restarts
+	WarningConfigMap string
+
+	// StuckTerminatingThreshold is the time after which jobs, pods, configmaps and secrets that are still terminating
+	// count as stuck and show up in reports; EscalateStuckTerminating force-deletes their dependents and removes their
+	// finalizers
+	StuckTerminatingThreshold time.Duration
+	EscalateStuckTerminating  bool
+
+	// Watch leaves cleaning jobs, pods, configmaps and secrets to informers instead of listing them every cycle
+	Watch bool
+}
+
+// PipelineFilter matches pipelines by globs on their source/owner/name path and regular expressions on their branch
+type PipelineFilter struct {
+	Pipelines []string
+	Branches  []*regexp.Regexp
+}
+
+// NewPipelineFilter validates the globs and compiles the branch regular expressions into a PipelineFilter
+func NewPipelineFilter(pipelines, branches []string) (filter PipelineFilter, err error) {
+	for _, p := range pipelines {
+		if _, err = path.Match(p, ""); err != nil {
+			return filter, fmt.Errorf("pipeline glob %v is invalid: %w", p, err)
+		}
+	}
+	filter.Pipelines = pipelines
+
+	for _, b := range branches {
+		re, err := regexp.Compile(b)
+		if err != nil {
+			return filter, fmt.Errorf("branch regular expression %v is invalid: %w", b, err)
+		}
+		filter.Branches = append(filter.Branches, re)
+	}
+
+	return filter, nil
+}
+
+// IsEmpty returns true if the filter has neither pipeline globs nor branch regular expressions
+func (f PipelineFilter) IsEmpty() bool {
+	return len(f.Pipelines) == 0 && len(f.Branches) == 0
+}
+
+// appliesTo returns true if the filter has anything to match on; releases have no branch, so a filter with only branch
+// regular expressions doesn't apply to them
+func (f PipelineFilter) appliesTo(branch *string) bool {
+	return len(f.Pipelines) > 0 || (len(f.Branches) > 0 && branch != nil)
+}
+
+// Matches returns true if a non-empty filter matches the pipeline path and branch; releases have no branch and pass nil,
+// in which case only the pipeline globs are checked and a filter with only branch regular expressions never matches
+func (f PipelineFilter) Matches(pipeline string, branch *string) bool {
+	if !f.appliesTo(branch) {
+		return false
+	}
+
+	if len(f.Pipelines) > 0 {
+		matched := false
+		for _, p := range f.Pipelines {
+			if ok, _ := path.Match(p, pipeline); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.Branches) > 0 && branch != nil {
+		matched := false
+		for _, re := range f.Branches {
+			if re.MatchString(*branch) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSelected returns true if a pipeline passes the include filter, if it applies, and doesn't match the exclude filter
+func (c Config) isSelected(pipeline string, branch *string) bool {
+	if c.Include.appliesTo(branch) && !c.Include.Matches(pipeline, branch) {
+		return false
+	}
+
+	return !c.Exclude.Matches(pipeline, branch)
+}
+
+// StatusRule defines after how long a build or release in a particular status is considered hanging; for builds in
+// canceling status the max age is the grace period since their last update before their job gets force-deleted
+type StatusRule struct {
+	Status       string
+	MaxAge       time.Duration
+	AgeReference AgeReference
+}
+
+// AgeReference defines which timestamp of a build or release its age is measured from
+type AgeReference string
+
+const (
+	// AgeReferenceInserted measures age from the moment the build or release got queued
+	AgeReferenceInserted AgeReference = "inserted"
+	// AgeReferenceStarted measures age from the moment its job got created, which is when its jwt got issued
+	AgeReferenceStarted AgeReference = "started"
+	// AgeReferenceUpdated measures age from its last status update in the api, e.g. when it started running or got
+	// canceled; log output doesn't update it, so it doesn't tell whether a running build or release is still active
+	AgeReferenceUpdated AgeReference = "updated"
+	// AgeReferenceLastLogActivity measures age from the last log line of its job pod, so a build or release only
+	// exceeds its max age once it stopped logging for that long; it reads the logs of every build or release it applies
+	// to each cycle and falls back to the started time while there's no running pod or log output to go by
+	AgeReferenceLastLogActivity AgeReference = "last-log-activity"
+)
+
+// DefaultAgeReference returns the age reference matching the jwt lifetime for running builds and releases, the queue
+// time for pending ones and the last status update for canceling ones
+func DefaultAgeReference(status string) AgeReference {
+	switch strings.ToLower(status) {
+	case "running":
+		return AgeReferenceStarted
+	case "canceling":
+		return AgeReferenceUpdated
+	default:
+		return AgeReferenceInserted
+	}
+}
+
+// referenceTime picks the timestamp to measure age from, falling back to the inserted time if the chosen one isn't set
+// yet; for the last log activity it's the started time the log activity gets looked up from
+func referenceTime(ageReference AgeReference, insertedAt, startedAt, updatedAt *time.Time) *time.Time {
+	switch ageReference {
+	case AgeReferenceStarted, AgeReferenceLastLogActivity:
+		if startedAt != nil && !startedAt.IsZero() {
+			return startedAt
+		}
+	case AgeReferenceUpdated:
+		if updatedAt != nil && !updatedAt.IsZero() {
+			return updatedAt
+		}
+	}
+
+	return insertedAt
+}
+
+// statuses returns the statuses of all enabled rules, a rule with zero max age is disabled
+func statuses(rules []StatusRule) (statuses []string) {
+	for _, r := range rules {
+		if r.MaxAge > 0 {
+			statuses = append(statuses, r.Status)
+		}
+	}
+
+	return
+}
+
+// ruleForStatus returns the enabled rule matching the status, if any
+func ruleForStatus(rules []StatusRule, status string) (rule StatusRule, ok bool) {
+	for _, r := range rules {
+		if strings.EqualFold(r.Status, status) && r.MaxAge > 0 {
+			if r.AgeReference == "" {
+				r.AgeReference = DefaultAgeReference(r.Status)
+			}
+			return r, true
+		}
+	}
+
+	return rule, false
+}
+
+// jobName returns the name estafette-ci-api gives to the kubernetes job for a build or release
+func jobName(jobType, repoName, id string) string {
+
+	// create job name of max 63 chars
+	maxJobNameLength := 63
+
+	re := regexp.MustCompile("[^a-zA-Z0-9]+")
+	repoName = re.ReplaceAllString(repoName, "-")
+
+	maxRepoNameLength := maxJobNameLength - len(jobType) - 1 - len(id) - 1
+	if maxRepoNameLength > 0 && len(repoName) > maxRepoNameLength {
+		repoName = repoName[:maxRepoNameLength]
+	}
+
+	return strings.ToLower(fmt.Sprintf("%v-%v-%v", jobType, repoName, id))
+}
@@ -0,0 +1,186 @@
+package cleaner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSelected(t *testing.T) {
+	t.Run("ReturnsTrueWithoutFilters", func(t *testing.T) {
+
+		config := Config{}
+		branch := "main"
+
+		// act
+		selected := config.isSelected("github.com/estafette/estafette-ci-api", &branch)
+
+		assert.True(t, selected)
+	})
+
+	t.Run("ReturnsFalseIfPipelineDoesNotMatchInclude", func(t *testing.T) {
+
+		include, err := NewPipelineFilter([]string{"github.com/estafette/*"}, nil)
+		assert.Nil(t, err)
+		config := Config{Include: include}
+		branch := "main"
+
+		// act
+		selected := config.isSelected("github.com/other/estafette-ci-api", &branch)
+
+		assert.False(t, selected)
+	})
+
+	t.Run("ReturnsFalseIfBranchDoesNotMatchInclude", func(t *testing.T) {
+
+		include, err := NewPipelineFilter([]string{"github.com/estafette/*"}, []string{"^main$"})
+		assert.Nil(t, err)
+		config := Config{Include: include}
+		branch := "feature"
+
+		// act
+		selected := config.isSelected("github.com/estafette/estafette-ci-api", &branch)
+
+		assert.False(t, selected)
+	})
+
+	t.Run("IgnoresBranchFiltersForReleases", func(t *testing.T) {
+
+		include, err := NewPipelineFilter([]string{"github.com/estafette/*"}, []string{"^main$"})
+		assert.Nil(t, err)
+		config := Config{Include: include}
+
+		// act
+		selected := config.isSelected("github.com/estafette/estafette-ci-api", nil)
+
+		assert.True(t, selected)
+	})
+
+	t.Run("ReturnsFalseIfPipelineMatchesExclude", func(t *testing.T) {
+
+		include, err := NewPipelineFilter([]string{"github.com/estafette/*"}, nil)
+		assert.Nil(t, err)
+		exclude, err := NewPipelineFilter([]string{"github.com/estafette/estafette-ci-api"}, nil)
+		assert.Nil(t, err)
+		config := Config{Include: include, Exclude: exclude}
+		branch := "main"
+
+		// act
+		selected := config.isSelected("github.com/estafette/estafette-ci-api", &branch)
+
+		assert.False(t, selected)
+	})
+
+	t.Run("ReturnsFalseIfBranchMatchesExclude", func(t *testing.T) {
+
+		exclude, err := NewPipelineFilter(nil, []string{"^experimental$"})
+		assert.Nil(t, err)
+		config := Config{Exclude: exclude}
+		branch := "experimental"
+
+		// act
+		selected := config.isSelected("github.com/estafette/estafette-ci-api", &branch)
+
+		assert.False(t, selected)
+	})
+
+	t.Run("DoesNotExcludeReleasesWithBranchOnlyExclude", func(t *testing.T) {
+
+		exclude, err := NewPipelineFilter(nil, []string{"^experimental$"})
+		assert.Nil(t, err)
+		config := Config{Exclude: exclude}
+
+		// act
+		selected := config.isSelected("github.com/estafette/estafette-ci-api", nil)
+
+		assert.True(t, selected)
+	})
+
+	t.Run("DoesNotFilterOutReleasesWithBranchOnlyInclude", func(t *testing.T) {
+
+		include, err := NewPipelineFilter(nil, []string{"^main$"})
+		assert.Nil(t, err)
+		config := Config{Include: include}
+
+		// act
+		selected := config.isSelected("github.com/estafette/estafette-ci-api", nil)
+
+		assert.True(t, selected)
+	})
+}
+
+func TestNewPipelineFilter(t *testing.T) {
+	t.Run("ReturnsErrorForInvalidBranchRegularExpression", func(t *testing.T) {
+
+		// act
+		_, err := NewPipelineFilter(nil, []string{"("})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestDefaultAgeReference(t *testing.T) {
+	t.Run("ReturnsStartedForRunning", func(t *testing.T) {
+
+		// act
+		ageReference := DefaultAgeReference("running")
+
+		assert.Equal(t, AgeReferenceStarted, ageReference)
+	})
+
+	t.Run("ReturnsInsertedForPending", func(t *testing.T) {
+
+		// act
+		ageReference := DefaultAgeReference("pending")
+
+		assert.Equal(t, AgeReferenceInserted, ageReference)
+	})
+
+	t.Run("ReturnsUpdatedForCanceling", func(t *testing.T) {
+
+		// act
+		ageReference := DefaultAgeReference("Canceling")
+
+		assert.Equal(t, AgeReferenceUpdated, ageReference)
+	})
+}
+
+func TestReferenceTime(t *testing.T) {
+
+	insertedAt := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	startedAt := time.Date(2022, 9, 1, 11, 0, 0, 0, time.UTC)
+	updatedAt := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("ReturnsTimeMatchingAgeReference", func(t *testing.T) {
+
+		// act
+		inserted := referenceTime(AgeReferenceInserted, &insertedAt, &startedAt, &updatedAt)
+		started := referenceTime(AgeReferenceStarted, &insertedAt, &startedAt, &updatedAt)
+		updated := referenceTime(AgeReferenceUpdated, &insertedAt, &startedAt, &updatedAt)
+		lastLogActivity := referenceTime(AgeReferenceLastLogActivity, &insertedAt, &startedAt, &updatedAt)
+
+		assert.Equal(t, insertedAt, *inserted)
+		assert.Equal(t, startedAt, *started)
+		assert.Equal(t, updatedAt, *updated)
+		assert.Equal(t, startedAt, *lastLogActivity)
+	})
+
+	t.Run("FallsBackToInsertedIfNotStartedYet", func(t *testing.T) {
+
+		// act
+		started := referenceTime(AgeReferenceStarted, &insertedAt, nil, &updatedAt)
+		lastLogActivity := referenceTime(AgeReferenceLastLogActivity, &insertedAt, &time.Time{}, &updatedAt)
+
+		assert.Equal(t, insertedAt, *started)
+		assert.Equal(t, insertedAt, *lastLogActivity)
+	})
+
+	t.Run("FallsBackToInsertedWithoutUpdate", func(t *testing.T) {
+
+		// act
+		updated := referenceTime(AgeReferenceUpdated, &insertedAt, &startedAt, nil)
+
+		assert.Equal(t, insertedAt, *updated)
+	})
+}
@@ -0,0 +1,186 @@
+package cleaner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the schema of the file passed with --config, in yaml or json (which is valid yaml as well); every
+// setting is optional, falls back to the default of its flag and gets overridden by its flag or environment variable:
+//
+//	builds:
+//	  running:
+//	    maxAge: 355m                    # --build-running-max-age, 0 disables
+//	    ageReference: started           # --build-running-age-reference: inserted, started, updated or last-log-activity
+//	  pending:
+//	    maxAge: 355m                    # --build-pending-max-age
+//	    ageReference: inserted          # --build-pending-age-reference
+//	  canceling:
+//	    maxAge: 15m                     # --build-canceling-grace-period
+//	    ageReference: updated           # --build-canceling-age-reference
+//	  markFailedAfterForceDelete: false # --mark-failed-after-force-delete
+//	  logInactivityTimeout: 0s          # --build-log-inactivity-timeout, 0 disables
+//	releases:
+//	  running:
+//	    maxAge: 355m                    # --release-running-max-age
+//	    ageReference: started           # --release-running-age-reference
+//	  pending:
+//	    maxAge: 355m                    # --release-pending-max-age
+//	    ageReference: inserted          # --release-pending-age-reference
+//	  canceling:
+//	    maxAge: 355m                    # --release-canceling-max-age
+//	    ageReference: updated           # --release-canceling-age-reference
+//	include:
+//	  pipelines: [github.com/estafette/*] # --include-pipeline
+//	  branches: [^main$]                  # --include-branch
+//	exclude:
+//	  pipelines: []                     # --exclude-pipeline
+//	  branches: []                      # --exclude-branch
+//	warnBeforeCancel: false             # --warn-before-cancel
+//	warningWebhook:
+//	  url: ""                           # --warning-webhook-url, empty disables
+//	  timeout: 10s                      # --warning-webhook-timeout
+//	nodeLostGracePeriod: 0s             # --node-lost-grace-period, 0 disables
+//	stuckTerminating:
+//	  threshold: 15m                    # --stuck-terminating-threshold, 0 disables
+//	  escalate: false                   # --escalate-stuck-terminating
+//	deletePolicies:
+//	  job:
+//	    propagationPolicy: Foreground   # --job-propagation-policy
+//	    gracePeriodSeconds: -1          # --job-grace-period-seconds, -1 uses the default of the job
+//	  pod:
+//	    propagationPolicy: Background   # --pod-propagation-policy
+//	    gracePeriodSeconds: -1          # --pod-grace-period-seconds
+//	  configmap:
+//	    propagationPolicy: Background   # --configmap-propagation-policy
+//	    gracePeriodSeconds: -1          # --configmap-grace-period-seconds
+//	  secret:
+//	    propagationPolicy: Background   # --secret-propagation-policy
+//	    gracePeriodSeconds: -1          # --secret-grace-period-seconds
+//	deletePrecondition: none            # --delete-precondition: none, uid or resource-version
+type ConfigFile struct {
+	Builds              BuildsConfigFile           `yaml:"builds"`
+	Releases            ReleasesConfigFile         `yaml:"releases"`
+	Include             PipelineFilterConfigFile   `yaml:"include"`
+	Exclude             PipelineFilterConfigFile   `yaml:"exclude"`
+	WarnBeforeCancel    *bool                      `yaml:"warnBeforeCancel"`
+	WarningWebhook      WebhookConfigFile          `yaml:"warningWebhook"`
+	NodeLostGracePeriod *time.Duration             `yaml:"nodeLostGracePeriod"`
+	StuckTerminating    StuckTerminatingConfigFile `yaml:"stuckTerminating"`
+	DeletePolicies      DeletePoliciesConfigFile   `yaml:"deletePolicies"`
+	DeletePrecondition  *string                    `yaml:"deletePrecondition"`
+}
+
+// WebhookConfigFile holds where warnings get posted to
+type WebhookConfigFile struct {
+	URL     *string        `yaml:"url"`
+	Timeout *time.Duration `yaml:"timeout"`
+}
+
+// DeletePoliciesConfigFile holds how jobs, pods, configmaps and secrets get deleted
+type DeletePoliciesConfigFile struct {
+	Job       DeletePolicyConfigFile `yaml:"job"`
+	Pod       DeletePolicyConfigFile `yaml:"pod"`
+	ConfigMap DeletePolicyConfigFile `yaml:"configmap"`
+	Secret    DeletePolicyConfigFile `yaml:"secret"`
+}
+
+// DeletePolicyConfigFile holds the propagation policy and grace period for deleting a kind; unset fields are nil
+type DeletePolicyConfigFile struct {
+	PropagationPolicy  *string `yaml:"propagationPolicy"`
+	GracePeriodSeconds *int64  `yaml:"gracePeriodSeconds"`
+}
+
+// StuckTerminatingConfigFile holds when objects count as stuck in terminating and whether to escalate
+type StuckTerminatingConfigFile struct {
+	Threshold *time.Duration `yaml:"threshold"`
+	Escalate  *bool          `yaml:"escalate"`
+}
+
+// BuildsConfigFile holds the rules for builds per status
+type BuildsConfigFile struct {
+	Running                    StatusRuleConfigFile `yaml:"running"`
+	Pending                    StatusRuleConfigFile `yaml:"pending"`
+	Canceling                  StatusRuleConfigFile `yaml:"canceling"`
+	MarkFailedAfterForceDelete *bool                `yaml:"markFailedAfterForceDelete"`
+	LogInactivityTimeout       *time.Duration       `yaml:"logInactivityTimeout"`
+}
+
+// ReleasesConfigFile holds the rules for releases per status
+type ReleasesConfigFile struct {
+	Running   StatusRuleConfigFile `yaml:"running"`
+	Pending   StatusRuleConfigFile `yaml:"pending"`
+	Canceling StatusRuleConfigFile `yaml:"canceling"`
+}
+
+// StatusRuleConfigFile holds the max age and age reference for a status; unset fields are nil
+type StatusRuleConfigFile struct {
+	MaxAge       *time.Duration `yaml:"maxAge"`
+	AgeReference *AgeReference  `yaml:"ageReference"`
+}
+
+// PipelineFilterConfigFile holds pipeline globs and branch regular expressions; unset fields are nil, while an empty
+// list clears the filter
+type PipelineFilterConfigFile struct {
+	Pipelines []string `yaml:"pipelines"`
+	Branches  []string `yaml:"branches"`
+}
+
+// UnmarshalYAML rejects unknown age references, reporting the line they're on
+func (r *AgeReference) UnmarshalYAML(node *yaml.Node) (err error) {
+	var value string
+	err = node.Decode(&value)
+	if err != nil {
+		return
+	}
+
+	switch AgeReference(value) {
+	case AgeReferenceInserted, AgeReferenceStarted, AgeReferenceUpdated, AgeReferenceLastLogActivity:
+		*r = AgeReference(value)
+		return nil
+	}
+
+	return fmt.Errorf("line %v: age reference %v is invalid, use %v, %v, %v or %v", node.Line, value, AgeReferenceInserted, AgeReferenceStarted, AgeReferenceUpdated, AgeReferenceLastLogActivity)
+}
+
+// UnmarshalYAML rejects invalid pipeline globs and branch regular expressions, reporting the line of the filter
+func (f *PipelineFilterConfigFile) UnmarshalYAML(node *yaml.Node) (err error) {
+	// decode into a type without this method to avoid recursing
+	type plain PipelineFilterConfigFile
+	err = node.Decode((*plain)(f))
+	if err != nil {
+		return
+	}
+
+	_, err = NewPipelineFilter(f.Pipelines, f.Branches)
+	if err != nil {
+		return fmt.Errorf("line %v: %w", node.Line, err)
+	}
+
+	return nil
+}
+
+// ReadConfigFile reads and strictly validates a config file, returning errors for unknown fields, invalid values and
+// their line numbers; an empty file is valid and sets nothing
+func ReadConfigFile(path string) (configFile ConfigFile, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return configFile, fmt.Errorf("config file %v can't be opened: %w", path, err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(&configFile)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return configFile, fmt.Errorf("config file %v is invalid: %w", path, err)
+	}
+
+	return configFile, nil
+}
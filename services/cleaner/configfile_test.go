@@ -0,0 +1,139 @@
+package cleaner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	t.Run("ReadsYaml", func(t *testing.T) {
+
+		path := writeConfigFile(t, "config.yaml", `
+builds:
+  running:
+    maxAge: 2h
+    ageReference: updated
+  markFailedAfterForceDelete: true
+exclude:
+  pipelines:
+  - github.com/estafette/*
+  branches: []
+`)
+
+		// act
+		configFile, err := ReadConfigFile(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2*time.Hour, *configFile.Builds.Running.MaxAge)
+		assert.Equal(t, AgeReferenceUpdated, *configFile.Builds.Running.AgeReference)
+		assert.Nil(t, configFile.Builds.Pending.MaxAge)
+		assert.True(t, *configFile.Builds.MarkFailedAfterForceDelete)
+		assert.Equal(t, []string{"github.com/estafette/*"}, configFile.Exclude.Pipelines)
+		assert.NotNil(t, configFile.Exclude.Branches)
+		assert.Nil(t, configFile.Include.Pipelines)
+	})
+
+	t.Run("ReadsJson", func(t *testing.T) {
+
+		path := writeConfigFile(t, "config.json", `{"releases": {"canceling": {"maxAge": "30m"}}}`)
+
+		// act
+		configFile, err := ReadConfigFile(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 30*time.Minute, *configFile.Releases.Canceling.MaxAge)
+	})
+
+	t.Run("ReadsDeletePoliciesAndWarningWebhook", func(t *testing.T) {
+
+		path := writeConfigFile(t, "config.yaml", `
+warningWebhook:
+  url: https://example.com/warnings
+  timeout: 5s
+deletePolicies:
+  pod:
+    propagationPolicy: Orphan
+    gracePeriodSeconds: 0
+deletePrecondition: uid
+`)
+
+		// act
+		configFile, err := ReadConfigFile(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "https://example.com/warnings", *configFile.WarningWebhook.URL)
+		assert.Equal(t, 5*time.Second, *configFile.WarningWebhook.Timeout)
+		assert.Equal(t, "Orphan", *configFile.DeletePolicies.Pod.PropagationPolicy)
+		assert.Equal(t, int64(0), *configFile.DeletePolicies.Pod.GracePeriodSeconds)
+		assert.Nil(t, configFile.DeletePolicies.Job.PropagationPolicy)
+		assert.Equal(t, "uid", *configFile.DeletePrecondition)
+	})
+
+	t.Run("AcceptsEmptyFile", func(t *testing.T) {
+
+		path := writeConfigFile(t, "config.yaml", "")
+
+		// act
+		_, err := ReadConfigFile(path)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsErrorWithLineForUnknownField", func(t *testing.T) {
+
+		path := writeConfigFile(t, "config.yaml", "builds:\n  running:\n    maxage: 2h\n")
+
+		// act
+		_, err := ReadConfigFile(path)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "line 3")
+	})
+
+	t.Run("ReturnsErrorWithLineForInvalidDuration", func(t *testing.T) {
+
+		path := writeConfigFile(t, "config.yaml", "builds:\n  pending:\n    maxAge: two hours\n")
+
+		// act
+		_, err := ReadConfigFile(path)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "line 3")
+	})
+
+	t.Run("ReturnsErrorWithLineForInvalidAgeReference", func(t *testing.T) {
+
+		path := writeConfigFile(t, "config.yaml", "releases:\n  running:\n    maxAge: 2h\n    ageReference: finished\n")
+
+		// act
+		_, err := ReadConfigFile(path)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "line 4")
+	})
+
+	t.Run("ReturnsErrorWithLineForInvalidBranchRegularExpression", func(t *testing.T) {
+
+		path := writeConfigFile(t, "config.yaml", "include:\n  branches:\n  - \"(main\"\n")
+
+		// act
+		_, err := ReadConfigFile(path)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "line 2")
+	})
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
@@ -0,0 +1,89 @@
+package cleaner
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ageReferenceLastLogOutput is the age reference of actions for builds whose job stopped logging
+const ageReferenceLastLogOutput = "last-log-output"
+
+// detectLogInactivity returns true if a running build's job pod logged nothing within the log inactivity timeout, along
+// with the time it's been silent since at least; builds without a running pod or that can't have their logs read are
+// left to their max age
+func (s *service) detectLogInactivity(ctx context.Context, b *contracts.Build, now time.Time) (silentSince time.Time, inactive bool) {
+	timeout := s.config.LogInactivityTimeout
+	if timeout <= 0 || !strings.EqualFold(b.BuildStatus, "running") || b.StartedAt == nil || now.Sub(*b.StartedAt) <= timeout {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+
+	pods, err := s.kubernetesapiClient.GetJobPods(ctx, jobName("build", b.RepoName, b.ID))
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed retrieving pods of build for pipeline %v with id %v, skipping log inactivity detection", b.GetFullRepoPath(), b.ID)
+		return
+	}
+
+	runningPods := 0
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		runningPods++
+
+		lastLogTime, err := s.kubernetesapiClient.GetLastLogTime(ctx, pod, timeout)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed reading logs of pod %v of build for pipeline %v with id %v, skipping log inactivity detection", pod.Name, b.GetFullRepoPath(), b.ID)
+			return
+		}
+		if lastLogTime != nil {
+			span.SetAttributes(attribute.String("log.last_output", lastLogTime.UTC().Format(time.RFC3339)))
+			return
+		}
+	}
+	if runningPods == 0 {
+		return
+	}
+
+	silentSince = now.Add(-timeout)
+	span.SetAttributes(attribute.String("log.silent_since", silentSince.Format(time.RFC3339)))
+	log.Info().Msgf("Build for pipeline %v with id %v logged nothing in its job pod for %v", b.GetFullRepoPath(), b.ID, timeout)
+
+	return silentSince, true
+}
+
+// lastLogActivity returns the time of the last log line of the running pods of a job, or the fallback if it has no
+// running pod, logged nothing yet or its logs can't be read
+func (s *service) lastLogActivity(ctx context.Context, jobName string, fallback time.Time) time.Time {
+	pods, err := s.kubernetesapiClient.GetJobPods(ctx, jobName)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed retrieving pods of job %v, measuring age from %v instead of its last log activity", jobName, fallback)
+		return fallback
+	}
+
+	lastActivity := fallback
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+
+		lastLogTime, err := s.kubernetesapiClient.GetLastLogTime(ctx, pod, 0)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed reading logs of pod %v of job %v, measuring age from %v instead of its last log activity", pod.Name, jobName, fallback)
+			return fallback
+		}
+		if lastLogTime != nil && lastLogTime.After(lastActivity) {
+			lastActivity = lastLogTime.UTC()
+		}
+	}
+
+	return lastActivity
+}
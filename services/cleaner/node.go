@@ -0,0 +1,116 @@
+package cleaner
+
+import (
+	"context"
+	"time"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ageReferenceNodeLost is the age reference of actions for builds and releases whose job pod is on a lost node
+const ageReferenceNodeLost = "node-lost"
+
+// evaluateLostNode force-deletes the job of a running build or release with a pod bound to a node that's been NotReady
+// or gone for longer than the node lost grace period, and cancels it; it returns true if it did. Warnings and protection
+// don't apply, since the job can't make progress anymore either way
+func (s *service) evaluateLostNode(ctx context.Context, report *corev1.CycleReport, action corev1.CleanupAction, jobName string, cancel func(ctx context.Context) error) (handled bool, err error) {
+	if s.config.NodeLostGracePeriod <= 0 {
+		return false, nil
+	}
+
+	nodeName, lostSince, lost := s.detectLostNode(ctx, jobName, action.Time)
+	if !lost {
+		return false, nil
+	}
+
+	action.Action = "force-delete"
+	action.Rule = action.Kind + "-node-lost"
+	action.Name = jobName
+	action.AgeReference = ageReferenceNodeLost
+	action.ReferenceTime = lostSince
+	action.Age = action.Time.Sub(lostSince)
+	action.MaxAge = s.config.NodeLostGracePeriod
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("decision", action.Action), attribute.String("rule", action.Rule), attribute.String("node", nodeName))
+
+	log.Info().Msgf("The %v for pipeline %v with id %v has its job %v on node %v, which is lost since %v; force deleting its job and canceling it", action.Kind, action.Pipeline, action.ID, jobName, nodeName, lostSince)
+	if !report.DryRun {
+		err = s.kubernetesapiClient.ForceDeleteJob(ctx, jobName)
+		if err == nil {
+			err = cancel(ctx)
+		}
+	}
+	s.addAction(ctx, report, action, err)
+
+	return true, err
+}
+
+// detectLostNode returns the node a pod of the job is bound to if that node has been NotReady or gone for longer than
+// the node lost grace period; failures to check are logged and leave the build or release to its max age
+func (s *service) detectLostNode(ctx context.Context, jobName string, now time.Time) (nodeName string, lostSince time.Time, lost bool) {
+	pods, err := s.kubernetesapiClient.GetJobPods(ctx, jobName)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed retrieving pods of job %v, skipping lost node detection", jobName)
+		return
+	}
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+
+		node, err := s.kubernetesapiClient.GetNode(ctx, pod.Spec.NodeName)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed retrieving node %v of pod %v, skipping lost node detection", pod.Spec.NodeName, pod.Name)
+			return
+		}
+
+		lostSince, lost = nodeLostSince(node, pod)
+		if lost && now.Sub(lostSince) > s.config.NodeLostGracePeriod {
+			return pod.Spec.NodeName, lostSince, true
+		}
+	}
+
+	return "", time.Time{}, false
+}
+
+// nodeLostSince returns since when a node isn't ready; for a node that's gone, that's when the pod stopped being ready
+// or started terminating, or when it got created if the pod doesn't tell
+func nodeLostSince(node *v1.Node, pod v1.Pod) (lostSince time.Time, lost bool) {
+	if node == nil {
+		if condition := podCondition(pod, v1.PodReady); condition != nil && condition.Status != v1.ConditionTrue {
+			return condition.LastTransitionTime.Time, true
+		}
+		if pod.DeletionTimestamp != nil {
+			return pod.DeletionTimestamp.Time, true
+		}
+		return pod.CreationTimestamp.Time, true
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			if condition.Status == v1.ConditionTrue {
+				return time.Time{}, false
+			}
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+
+	// a node that never reported being ready is still starting
+	return time.Time{}, false
+}
+
+func podCondition(pod v1.Pod, conditionType v1.PodConditionType) *v1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == conditionType {
+			return &pod.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
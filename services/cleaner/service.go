@@ -2,98 +2,239 @@ package cleaner
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	contracts "github.com/estafette/estafette-ci-contracts"
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
 	estafetteciapi "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/estafetteciapi"
 	kubernetesapi "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/kubernetesapi"
-	"github.com/opentracing/opentracing-go"
+	webhook "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/webhook"
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/internal/tracing"
+	audit "github.com/estafette/estafette-ci-hanging-job-cleaner/services/audit"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+var tracer = otel.Tracer("github.com/estafette/estafette-ci-hanging-job-cleaner/services/cleaner")
+
 type Service interface {
 	Init(ctx context.Context) (err error)
-	Clean(ctx context.Context) (err error)
+	Clean(ctx context.Context, dryRun bool) (report corev1.CycleReport, err error)
+	Watch(ctx context.Context) (err error)
+	Candidates(ctx context.Context) (report corev1.CycleReport, err error)
+	LatestReport() (report *corev1.CycleReport)
+	SetConfig(config Config)
 }
 
-func NewService(estafetteciapiClient estafetteciapi.Client, kubernetesapiClient kubernetesapi.Client) (Service, error) {
+func NewService(estafetteciapiClient estafetteciapi.Client, kubernetesapiClient kubernetesapi.Client, webhookClient webhook.Client, auditService audit.Service, config Config) (Service, error) {
 	return &service{
 		estafetteciapiClient: estafetteciapiClient,
 		kubernetesapiClient:  kubernetesapiClient,
+		webhookClient:        webhookClient,
+		auditService:         auditService,
+		config:               config,
+		warnings:             map[string]time.Time{},
 	}, nil
 }
 
 type service struct {
 	estafetteciapiClient estafetteciapi.Client
 	kubernetesapiClient  kubernetesapi.Client
+	webhookClient        webhook.Client
+	auditService         audit.Service
+	config               Config
+
+	// warnings holds when builds and releases got warned about in previous cycles, while nextWarnings collects those
+	// to carry over to the next cycle; both are only touched while holding cycleMutex
+	warnings     map[string]time.Time
+	nextWarnings map[string]time.Time
+
+	// cycleMutex prevents cycles triggered through the admin api from overlapping scheduled ones and config reloads
+	// from changing the config mid-cycle; configMutex lets the watcher read the config outside of cycles
+	cycleMutex   sync.Mutex
+	configMutex  sync.RWMutex
+	reportMutex  sync.RWMutex
+	latestReport *corev1.CycleReport
 }
 
 func (s *service) Init(ctx context.Context) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "cleaner.Service:Init")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "cleaner.Service:Init")
+	defer func() { tracing.End(span, err) }()
 
 	_, err = s.estafetteciapiClient.GetToken(ctx)
 	if err != nil {
 		return
 	}
 
+	err = s.kubernetesapiClient.Ping(ctx)
+	if err != nil {
+		return
+	}
+
 	return
 }
 
-func (s *service) Clean(ctx context.Context) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "cleaner.Service:Clean")
-	defer span.Finish()
+func (s *service) Clean(ctx context.Context, dryRun bool) (report corev1.CycleReport, err error) {
+	// every cycle gets a trace of its own, linked to the long-lived span of the daemon rather than nested in it
+	ctx, span := tracer.Start(ctx, "cleaner.Service:Clean", trace.WithNewRoot(), trace.WithLinks(trace.LinkFromContext(ctx)))
+	defer func() { tracing.End(span, err) }()
+
+	report, err = s.clean(ctx, dryRun)
+
+	s.reportMutex.Lock()
+	defer s.reportMutex.Unlock()
+	s.latestReport = &report
+
+	return
+}
+
+// Candidates evaluates what would be cleaned right now, without touching anything or replacing the latest report
+func (s *service) Candidates(ctx context.Context) (report corev1.CycleReport, err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:Candidates", trace.WithNewRoot(), trace.WithLinks(trace.LinkFromContext(ctx)))
+	defer func() { tracing.End(span, err) }()
+
+	return s.clean(ctx, true)
+}
+
+func (s *service) LatestReport() (report *corev1.CycleReport) {
+	s.reportMutex.RLock()
+	defer s.reportMutex.RUnlock()
+
+	return s.latestReport
+}
+
+// SetConfig replaces the rules and filters, waiting for a running cycle to finish so it doesn't mix old and new ones
+func (s *service) SetConfig(config Config) {
+	s.cycleMutex.Lock()
+	defer s.cycleMutex.Unlock()
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	s.config = config
+}
 
-	err = s.cleanBuilds(ctx)
+// currentConfig returns the config for use outside of cycles
+func (s *service) currentConfig() Config {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	return s.config
+}
+
+func (s *service) clean(ctx context.Context, dryRun bool) (report corev1.CycleReport, err error) {
+	s.cycleMutex.Lock()
+	defer s.cycleMutex.Unlock()
+
+	report.StartedAt = time.Now().UTC()
+	report.DryRun = dryRun
+	defer func() {
+		report.FinishedAt = time.Now().UTC()
+	}()
+
+	// the timeout only starts once a running cycle finished, and saving warnings doesn't count towards it
+	cycleCtx := ctx
+	if s.config.CycleTimeout > 0 {
+		var cancel context.CancelFunc
+		cycleCtx, cancel = context.WithTimeout(ctx, s.config.CycleTimeout)
+		defer cancel()
+	}
+
+	persistWarnings := s.config.WarnBeforeCancel && s.config.WarningConfigMap != ""
+	if persistWarnings {
+		loadErr := s.loadWarnings(cycleCtx)
+		if loadErr != nil {
+			log.Warn().Err(loadErr).Msgf("Failed loading warnings from configmap %v, using the ones in memory", s.config.WarningConfigMap)
+		}
+	}
+
+	if !dryRun {
+		s.nextWarnings = map[string]time.Time{}
+		defer func() {
+			s.rotateWarnings(err)
+			if persistWarnings {
+				// failing to save only means builds and releases get warned about again, it shouldn't fail the cycle
+				saveErr := s.saveWarnings(ctx)
+				if saveErr != nil {
+					log.Warn().Err(saveErr).Msgf("Failed saving warnings to configmap %v", s.config.WarningConfigMap)
+				}
+			}
+		}()
+	}
+
+	err = s.cleanBuilds(cycleCtx, &report)
 	if err != nil {
 		return
 	}
 
-	err = s.cleanReleases(ctx)
+	err = s.cleanReleases(cycleCtx, &report)
 	if err != nil {
 		return
 	}
 
-	err = s.cleanJobs(ctx)
+	// in watch mode informers take care of jobs, configmaps and secrets the moment they exceed their max age
+	if s.config.Watch {
+		return report, nil
+	}
+
+	err = s.cleanJobs(cycleCtx, &report)
 	if err != nil {
 		return
 	}
 
-	err = s.cleanConfigMaps(ctx)
+	err = s.cleanConfigMaps(cycleCtx, &report)
 	if err != nil {
 		return
 	}
 
-	err = s.cleanSecrets(ctx)
+	err = s.cleanSecrets(cycleCtx, &report)
 	if err != nil {
 		return
 	}
 
-	return nil
+	return report, nil
 }
 
-func (s *service) cleanBuilds(ctx context.Context) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "cleaner.Service:cleanBuilds")
-	defer span.Finish()
+func (s *service) cleanBuilds(ctx context.Context, report *corev1.CycleReport) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:cleanBuilds")
+	defer func() { tracing.End(span, err) }()
+
+	statuses := statuses(s.config.BuildRules)
+	if len(statuses) == 0 {
+		return nil
+	}
 
-	maxAgeMinutes := float64(6*60 - 5)
 	pageNumber := 1
 	pageSize := 12
 
 	for {
-		pagedBuilds, err := s.estafetteciapiClient.GetRunningBuilds(ctx, pageNumber, pageSize)
+		// stop paging as soon as the cycle gets canceled or exceeds its timeout
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		pagedBuilds, err := s.estafetteciapiClient.GetBuilds(ctx, statuses, pageNumber, pageSize)
 		if err != nil {
 			return err
 		}
 
-		// cancel builds close to 6 hours old (max lifetime of their jwt and last chance to send their logs to the api)
+		// cancel builds that exceed the max age for their status
 		for _, b := range pagedBuilds.Items {
 			if b == nil {
 				continue
 			}
-			if time.Now().UTC().Sub(b.InsertedAt).Minutes() > maxAgeMinutes {
-				err = s.estafetteciapiClient.CancelBuild(ctx, b)
-				if err != nil {
-					return err
-				}
+			err = s.evaluateBuild(ctx, report, b)
+			if err != nil {
+				return err
 			}
 		}
 
@@ -107,30 +248,172 @@ func (s *service) cleanBuilds(ctx context.Context) (err error) {
 	return nil
 }
 
-func (s *service) cleanReleases(ctx context.Context) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "cleaner.Service:cleanReleases")
-	defer span.Finish()
+// evaluateBuild cancels a build exceeding the max age for its status or that stopped logging, force-deletes its job if
+// it exceeds the grace period in canceling or runs on a lost node; its span shows why the build was or wasn't cleaned
+func (s *service) evaluateBuild(ctx context.Context, report *corev1.CycleReport, b *contracts.Build) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:evaluateBuild", trace.WithAttributes(
+		attribute.String("kind", "build"),
+		attribute.String("pipeline", b.GetFullRepoPath()),
+		attribute.String("branch", b.RepoBranch),
+		attribute.String("build.id", b.ID),
+		attribute.String("status", b.BuildStatus),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if !s.config.isSelected(b.GetFullRepoPath(), &b.RepoBranch) {
+		log.Debug().Msgf("Skipping build for pipeline %v on branch %v with id %v, it's filtered out", b.GetFullRepoPath(), b.RepoBranch, b.ID)
+		span.SetAttributes(attribute.String("decision", "skip-filtered-out"))
+		return nil
+	}
+	rule, ok := ruleForStatus(s.config.BuildRules, b.BuildStatus)
+	if !ok {
+		span.SetAttributes(attribute.String("decision", "skip-no-rule"))
+		return nil
+	}
+
+	now := time.Now().UTC()
+	referenceTime := *referenceTime(rule.AgeReference, &b.InsertedAt, b.StartedAt, &b.UpdatedAt)
+	if rule.AgeReference == AgeReferenceLastLogActivity {
+		referenceTime = s.lastLogActivity(ctx, jobName("build", b.RepoName, b.ID), referenceTime)
+	}
+	age := now.Sub(referenceTime)
+	setAgeAttributes(span, rule.AgeReference, referenceTime, age, rule.MaxAge)
+
+	action := corev1.CleanupAction{
+		Time:          now,
+		Kind:          "build",
+		Pipeline:      b.GetFullRepoPath(),
+		ID:            b.ID,
+		Status:        b.BuildStatus,
+		AgeReference:  string(rule.AgeReference),
+		ReferenceTime: referenceTime,
+		Age:           age,
+		MaxAge:        rule.MaxAge,
+	}
+
+	if strings.EqualFold(b.BuildStatus, "running") {
+		handled, err := s.evaluateLostNode(ctx, report, action, jobName("build", b.RepoName, b.ID), func(ctx context.Context) error {
+			return s.estafetteciapiClient.CancelBuild(ctx, b)
+		})
+		if handled {
+			return err
+		}
+	}
+
+	if age <= rule.MaxAge {
+		silentSince, inactive := s.detectLogInactivity(ctx, b, now)
+		if !inactive {
+			span.SetAttributes(attribute.String("decision", "keep"))
+			s.clearWarning(ctx, report, "build", b.ID, jobName("build", b.RepoName, b.ID))
+			return nil
+		}
+
+		// the build is within its max age but stopped logging, so it's frozen rather than just long-running
+		action.Rule = "build-log-inactivity"
+		action.AgeReference = ageReferenceLastLogOutput
+		action.ReferenceTime = silentSince
+		action.Age = now.Sub(silentSince)
+		action.MaxAge = s.config.LogInactivityTimeout
+	}
+
+	if strings.EqualFold(b.BuildStatus, "canceling") {
+		// once its job is force-deleted a build can remain canceling in the api, which leaves nothing to escalate
+		var job *batchv1.Job
+		job, err = s.kubernetesapiClient.GetJob(ctx, jobName("build", b.RepoName, b.ID))
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			log.Debug().Msgf("Skipping build for pipeline %v with id %v, it's %v but its job is gone", action.Pipeline, b.ID, b.BuildStatus)
+			span.SetAttributes(attribute.String("decision", "skip-job-gone"))
+			return nil
+		}
+
+		// the build has been canceled before but its job never acknowledged it, escalate
+		action.Action = "force-delete"
+		action.Rule = "build-canceling-grace-period"
+		span.SetAttributes(attribute.String("decision", action.Action), attribute.String("rule", action.Rule))
+		log.Info().Msgf("Build for pipeline %v with id %v is %v for %v since %v at %v, exceeding grace period %v", action.Pipeline, b.ID, b.BuildStatus, age, rule.AgeReference, referenceTime, rule.MaxAge)
+		if !report.DryRun {
+			err = s.escalateBuild(ctx, b)
+		}
+	} else {
+		if action.Rule == "" {
+			action.Rule = fmt.Sprintf("build-%v-max-age", strings.ToLower(b.BuildStatus))
+		}
+		if s.config.WarnBeforeCancel {
+			cancel, err := s.warnBeforeCancel(ctx, report, action, jobName("build", b.RepoName, b.ID))
+			if err != nil || !cancel {
+				return err
+			}
+		}
+		action.Action = "cancel"
+		span.SetAttributes(attribute.String("decision", action.Action), attribute.String("rule", action.Rule))
+		log.Info().Msgf("Build for pipeline %v with id %v is %v for %v since %v at %v, exceeding max age %v", action.Pipeline, b.ID, b.BuildStatus, action.Age, action.AgeReference, action.ReferenceTime, action.MaxAge)
+		if !report.DryRun {
+			err = s.estafetteciapiClient.CancelBuild(ctx, b)
+		}
+	}
+	s.addAction(ctx, report, action, err)
+
+	return err
+}
+
+func (s *service) escalateBuild(ctx context.Context, build *contracts.Build) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:escalateBuild")
+	defer func() { tracing.End(span, err) }()
+
+	log.Warn().Msgf("Build for pipeline %v/%v/%v with id %v is stuck in canceling since %v, force deleting its job...", build.RepoSource, build.RepoOwner, build.RepoName, build.ID, build.UpdatedAt)
+
+	err = s.kubernetesapiClient.ForceDeleteJob(ctx, jobName("build", build.RepoName, build.ID))
+	if err != nil {
+		return err
+	}
+
+	if s.config.MarkFailedAfterForceDelete {
+		// not every api version supports updating the build status, so don't fail the cleanup on it
+		err = s.estafetteciapiClient.MarkBuildFailed(ctx, build)
+		if estafetteciapi.HasStatusCode(err, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented) {
+			log.Info().Msgf("The api doesn't support marking build for pipeline %v/%v/%v with id %v as failed, it remains canceling", build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
+		} else if err != nil {
+			log.Warn().Err(err).Msgf("Marking build for pipeline %v/%v/%v with id %v as failed is not possible, it remains canceling", build.RepoSource, build.RepoOwner, build.RepoName, build.ID)
+		}
+	}
+
+	return nil
+}
+
+func (s *service) cleanReleases(ctx context.Context, report *corev1.CycleReport) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:cleanReleases")
+	defer func() { tracing.End(span, err) }()
+
+	statuses := statuses(s.config.ReleaseRules)
+	if len(statuses) == 0 {
+		return nil
+	}
 
-	maxAgeMinutes := float64(6*60 - 5)
 	pageNumber := 1
 	pageSize := 12
 
 	for {
-		pagedReleases, err := s.estafetteciapiClient.GetRunningReleases(ctx, pageNumber, pageSize)
+		// stop paging as soon as the cycle gets canceled or exceeds its timeout
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		pagedReleases, err := s.estafetteciapiClient.GetReleases(ctx, statuses, pageNumber, pageSize)
 		if err != nil {
 			return err
 		}
 
-		// cancel releases close to 6 hours old (max lifetime of their jwt and last chance to send their logs to the api)
+		// cancel releases that exceed the max age for their status
 		for _, r := range pagedReleases.Items {
 			if r == nil || r.InsertedAt == nil {
 				continue
 			}
-			if time.Now().UTC().Sub(*r.InsertedAt).Minutes() > maxAgeMinutes {
-				err = s.estafetteciapiClient.CancelRelease(ctx, r)
-				if err != nil {
-					return err
-				}
+			err = s.evaluateRelease(ctx, report, r)
+			if err != nil {
+				return err
 			}
 		}
 
@@ -144,74 +427,214 @@ func (s *service) cleanReleases(ctx context.Context) (err error) {
 	return nil
 }
 
-func (s *service) cleanJobs(ctx context.Context) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "cleaner.Service:cleanJobs")
-	defer span.Finish()
+// evaluateRelease cancels a release exceeding the max age for its status, or force-deletes its job first if it runs on a
+// lost node; its span shows why the release was or wasn't cleaned
+func (s *service) evaluateRelease(ctx context.Context, report *corev1.CycleReport, r *contracts.Release) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:evaluateRelease", trace.WithAttributes(
+		attribute.String("kind", "release"),
+		attribute.String("pipeline", r.GetFullRepoPath()),
+		attribute.String("release.id", r.ID),
+		attribute.String("status", r.ReleaseStatus),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if !s.config.isSelected(r.GetFullRepoPath(), nil) {
+		log.Debug().Msgf("Skipping release for pipeline %v with id %v, it's filtered out", r.GetFullRepoPath(), r.ID)
+		span.SetAttributes(attribute.String("decision", "skip-filtered-out"))
+		return nil
+	}
+	rule, ok := ruleForStatus(s.config.ReleaseRules, r.ReleaseStatus)
+	if !ok {
+		span.SetAttributes(attribute.String("decision", "skip-no-rule"))
+		return nil
+	}
 
-	maxAgeMinutes := float64(6*60 + 5)
+	now := time.Now().UTC()
+	referenceTime := *referenceTime(rule.AgeReference, r.InsertedAt, r.StartedAt, r.UpdatedAt)
+	if rule.AgeReference == AgeReferenceLastLogActivity {
+		referenceTime = s.lastLogActivity(ctx, jobName("release", r.RepoName, r.ID), referenceTime)
+	}
+	age := now.Sub(referenceTime)
+	setAgeAttributes(span, rule.AgeReference, referenceTime, age, rule.MaxAge)
+
+	action := corev1.CleanupAction{
+		Time:          now,
+		Kind:          "release",
+		Pipeline:      r.GetFullRepoPath(),
+		ID:            r.ID,
+		Status:        r.ReleaseStatus,
+		Rule:          fmt.Sprintf("release-%v-max-age", strings.ToLower(r.ReleaseStatus)),
+		AgeReference:  string(rule.AgeReference),
+		ReferenceTime: referenceTime,
+		Age:           age,
+		MaxAge:        rule.MaxAge,
+	}
 
-	jobs, err := s.kubernetesapiClient.GetJobs(ctx)
-	if err != nil {
-		return err
+	if strings.EqualFold(r.ReleaseStatus, "running") {
+		handled, err := s.evaluateLostNode(ctx, report, action, jobName("release", r.RepoName, r.ID), func(ctx context.Context) error {
+			return s.estafetteciapiClient.CancelRelease(ctx, r)
+		})
+		if handled {
+			return err
+		}
 	}
 
-	for _, j := range jobs {
-		// jobs that are older than max jwt lifetime missed being canceled properly, delete them
-		if time.Now().UTC().Sub(j.CreationTimestamp.Time).Minutes() > maxAgeMinutes {
-			err = s.kubernetesapiClient.DeleteJob(ctx, j)
-			if err != nil {
-				return err
-			}
+	if age <= rule.MaxAge {
+		span.SetAttributes(attribute.String("decision", "keep"))
+		s.clearWarning(ctx, report, "release", r.ID, jobName("release", r.RepoName, r.ID))
+		return nil
+	}
+
+	if s.config.WarnBeforeCancel {
+		cancel, err := s.warnBeforeCancel(ctx, report, action, jobName("release", r.RepoName, r.ID))
+		if err != nil || !cancel {
+			return err
 		}
 	}
 
-	return nil
+	action.Action = "cancel"
+	span.SetAttributes(attribute.String("decision", action.Action), attribute.String("rule", action.Rule))
+
+	log.Info().Msgf("Release for pipeline %v with id %v is %v for %v since %v at %v, exceeding max age %v", action.Pipeline, r.ID, r.ReleaseStatus, age, rule.AgeReference, referenceTime, rule.MaxAge)
+	if !report.DryRun {
+		err = s.estafetteciapiClient.CancelRelease(ctx, r)
+	}
+	s.addAction(ctx, report, action, err)
+
+	return err
 }
 
-func (s *service) cleanConfigMaps(ctx context.Context) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "cleaner.Service:cleanConfigMaps")
-	defer span.Finish()
+func (s *service) cleanJobs(ctx context.Context, report *corev1.CycleReport) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:cleanJobs")
+	defer func() { tracing.End(span, err) }()
 
-	maxAgeMinutes := float64(6*60 + 5)
+	maxAge := kubernetesObjectMaxAge
 
-	configmaps, err := s.kubernetesapiClient.GetConfigMaps(ctx)
-	if err != nil {
-		return err
-	}
+	return s.kubernetesapiClient.GetJobs(ctx, func(j batchv1.Job) error {
+		// jobs that are older than max jwt lifetime missed being canceled properly, delete them
+		return s.evaluateKubernetesObject(ctx, report, "job", j.ObjectMeta, maxAge, func(ctx context.Context) error {
+			return s.kubernetesapiClient.DeleteJob(ctx, j)
+		})
+	})
+}
+
+func (s *service) cleanConfigMaps(ctx context.Context, report *corev1.CycleReport) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:cleanConfigMaps")
+	defer func() { tracing.End(span, err) }()
 
-	for _, c := range configmaps {
+	maxAge := kubernetesObjectMaxAge
+
+	return s.kubernetesapiClient.GetConfigMaps(ctx, func(c v1.ConfigMap) error {
 		// configmaps that are older than max jwt lifetime missed being canceled properly, delete them
-		if time.Now().UTC().Sub(c.CreationTimestamp.Time).Minutes() > maxAgeMinutes {
-			err = s.kubernetesapiClient.DeleteConfigMap(ctx, c)
-			if err != nil {
-				return err
-			}
-		}
+		return s.evaluateKubernetesObject(ctx, report, "configmap", c.ObjectMeta, maxAge, func(ctx context.Context) error {
+			return s.kubernetesapiClient.DeleteConfigMap(ctx, c)
+		})
+	})
+}
+
+func (s *service) cleanSecrets(ctx context.Context, report *corev1.CycleReport) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:cleanSecrets")
+	defer func() { tracing.End(span, err) }()
+
+	maxAge := kubernetesObjectMaxAge
+
+	return s.kubernetesapiClient.GetSecrets(ctx, func(sec v1.Secret) error {
+		// secrets that are older than max jwt lifetime missed being canceled properly, delete them
+		return s.evaluateKubernetesObject(ctx, report, "secret", sec.ObjectMeta, maxAge, func(ctx context.Context) error {
+			return s.kubernetesapiClient.DeleteSecret(ctx, sec)
+		})
+	})
+}
+
+// evaluateKubernetesObject deletes a job, configmap or secret exceeding max age since its creation, or checks whether it's
+// stuck if it's terminating already; its span shows why the object was or wasn't cleaned
+func (s *service) evaluateKubernetesObject(ctx context.Context, report *corev1.CycleReport, kind string, meta metav1.ObjectMeta, maxAge time.Duration, deleteObject func(ctx context.Context) error) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:evaluateKubernetesObject", trace.WithAttributes(
+		attribute.String("kind", kind),
+		attribute.String("namespace", meta.Namespace),
+		attribute.String("name", meta.Name),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	// deleting an object that's already terminating again doesn't help, it's either on its way out or stuck
+	if meta.DeletionTimestamp != nil {
+		return s.evaluateTerminating(ctx, report, s.config, kind, meta)
 	}
 
-	return nil
+	now := time.Now().UTC()
+	action := kubernetesAction(now, kind, meta, maxAge)
+	setAgeAttributes(span, AgeReference(action.AgeReference), action.ReferenceTime, action.Age, maxAge)
+	if action.Age <= maxAge {
+		span.SetAttributes(attribute.String("decision", "keep"))
+		return nil
+	}
+	span.SetAttributes(attribute.String("decision", action.Action), attribute.String("rule", action.Rule))
+
+	if !report.DryRun {
+		err = deleteObject(ctx)
+	}
+	s.addAction(ctx, report, action, err)
+
+	// an object that changed since it was listed gets evaluated again next cycle
+	if errors.Is(err, kubernetesapi.ErrPreconditionFailed) {
+		span.SetAttributes(attribute.String("decision", "skip-precondition-failed"))
+		return nil
+	}
+
+	return err
 }
 
-func (s *service) cleanSecrets(ctx context.Context) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "cleaner.Service:cleanSecrets")
-	defer span.Finish()
+// setAgeAttributes adds what an age-based decision is based on to a span
+func setAgeAttributes(span trace.Span, ageReference AgeReference, referenceTime time.Time, age, maxAge time.Duration) {
+	span.SetAttributes(
+		attribute.String("age.reference", string(ageReference)),
+		attribute.String("age.reference_time", referenceTime.Format(time.RFC3339)),
+		attribute.Float64("age.seconds", age.Seconds()),
+		attribute.Float64("max_age.seconds", maxAge.Seconds()),
+	)
+}
 
-	maxAgeMinutes := float64(6*60 + 5)
+// kubernetesAction describes the deletion of a kubernetes object, whose age is always measured from its creation
+func kubernetesAction(now time.Time, kind string, meta metav1.ObjectMeta, maxAge time.Duration) corev1.CleanupAction {
+	return corev1.CleanupAction{
+		Time:          now,
+		Kind:          kind,
+		Namespace:     meta.Namespace,
+		Name:          meta.Name,
+		Rule:          fmt.Sprintf("%v-max-age", kind),
+		AgeReference:  "created",
+		ReferenceTime: meta.CreationTimestamp.Time,
+		Age:           now.Sub(meta.CreationTimestamp.Time),
+		MaxAge:        maxAge,
+		Action:        "delete",
+	}
+}
 
-	secrets, err := s.kubernetesapiClient.GetSecrets(ctx)
-	if err != nil {
-		return err
+// addAction appends an action to the report, including the result it had, and records it in the audit trail unless in dry run
+func (s *service) addAction(ctx context.Context, report *corev1.CycleReport, action corev1.CleanupAction, err error) {
+	switch {
+	case report.DryRun:
+		action.Result = "dry-run"
+	case errors.Is(err, kubernetesapi.ErrPreconditionFailed):
+		action.Result = "skipped"
+		action.Error = err.Error()
+	case err != nil:
+		action.Result = "failed"
+		action.Error = err.Error()
+	default:
+		action.Result = "succeeded"
 	}
+	report.Actions = append(report.Actions, action)
 
-	for _, sec := range secrets {
-		// secrets that are older than max jwt lifetime missed being canceled properly, delete them
-		if time.Now().UTC().Sub(sec.CreationTimestamp.Time).Minutes() > maxAgeMinutes {
-			err = s.kubernetesapiClient.DeleteSecret(ctx, sec)
-			if err != nil {
-				return err
-			}
-		}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("result", action.Result))
+
+	if report.DryRun {
+		return
 	}
 
-	return nil
+	// failing to audit shouldn't stop the cleanup
+	auditErr := s.auditService.Record(ctx, action)
+	if auditErr != nil {
+		log.Warn().Err(auditErr).Msgf("Failed recording %v of %v %v%v in audit trail", action.Action, action.Kind, action.Pipeline, action.Name)
+	}
 }
@@ -0,0 +1,1003 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	estafetteciapi "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/estafetteciapi"
+	kubernetesapi "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/kubernetesapi"
+	webhook "github.com/estafette/estafette-ci-hanging-job-cleaner/clients/webhook"
+	audit "github.com/estafette/estafette-ci-hanging-job-cleaner/services/audit"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// spanRecorder records the spans of all tests, since the package tracer only picks up the first global tracer provider
+var spanRecorder = tracetest.NewSpanRecorder()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+}
+
+func TestCleanBuilds(t *testing.T) {
+	t.Run("StopsPagingWhenContextIsCanceled", func(t *testing.T) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pagesRetrieved := 0
+		estafetteciapiClient := &fakeEstafetteciapiClient{
+			getBuilds: func(ctx context.Context, statuses []string, pageNumber, pageSize int) (corev1.PagedBuildResponse, error) {
+				pagesRetrieved++
+				// the cycle gets canceled while the first page is being processed
+				cancel()
+				return corev1.PagedBuildResponse{
+					Items:      []*contracts.Build{},
+					Pagination: contracts.Pagination{Page: pageNumber, Size: pageSize, TotalPages: 100},
+				}, nil
+			},
+		}
+		s := &service{
+			estafetteciapiClient: estafetteciapiClient,
+			config: Config{
+				BuildRules: []StatusRule{{Status: "running", MaxAge: 1}},
+			},
+		}
+
+		// act
+		err := s.cleanBuilds(ctx, &corev1.CycleReport{})
+
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, 1, pagesRetrieved)
+	})
+}
+
+func TestClean(t *testing.T) {
+	t.Run("AbortsCycleExceedingCycleTimeout", func(t *testing.T) {
+
+		s := &service{
+			estafetteciapiClient: &fakeEstafetteciapiClient{
+				getBuilds: func(ctx context.Context, statuses []string, pageNumber, pageSize int) (corev1.PagedBuildResponse, error) {
+					<-ctx.Done()
+					return corev1.PagedBuildResponse{}, ctx.Err()
+				},
+			},
+			config: Config{
+				BuildRules:   []StatusRule{{Status: "running", MaxAge: time.Hour}},
+				CycleTimeout: 10 * time.Millisecond,
+			},
+		}
+
+		// act
+		_, err := s.Clean(context.Background(), true)
+
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("StartsNewTraceForEveryCycleLinkedToParent", func(t *testing.T) {
+
+		// in watch mode a cycle without rules has nothing to list
+		s := &service{config: Config{Watch: true}}
+		ctx, parent := otel.Tracer("test").Start(context.Background(), "main")
+		defer parent.End()
+
+		// act
+		_, err := s.Clean(ctx, true)
+		assert.Nil(t, err)
+		first := lastEndedSpan(spanRecorder)
+		_, err = s.Clean(ctx, true)
+		assert.Nil(t, err)
+		second := lastEndedSpan(spanRecorder)
+
+		assert.Equal(t, "cleaner.Service:Clean", second.Name())
+		assert.NotEqual(t, parent.SpanContext().TraceID(), first.SpanContext().TraceID())
+		assert.NotEqual(t, first.SpanContext().TraceID(), second.SpanContext().TraceID())
+		assert.False(t, second.Parent().IsValid())
+		if assert.Equal(t, 1, len(second.Links())) {
+			assert.Equal(t, parent.SpanContext().SpanID(), second.Links()[0].SpanContext.SpanID())
+		}
+	})
+}
+
+func TestEvaluateBuild(t *testing.T) {
+	t.Run("RecordsDecisionOnSpan", func(t *testing.T) {
+
+		s := &service{
+			config: Config{
+				BuildRules: []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceInserted}},
+			},
+		}
+		build := &contracts.Build{ID: "1", RepoSource: "github.com", RepoOwner: "estafette", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: time.Now().Add(-2 * time.Hour)}
+
+		// act
+		err := s.evaluateBuild(context.Background(), &corev1.CycleReport{DryRun: true}, build)
+
+		assert.Nil(t, err)
+		span := lastEndedSpan(spanRecorder)
+		assert.Equal(t, "cleaner.Service:evaluateBuild", span.Name())
+		assert.Contains(t, span.Attributes(), attribute.String("build.id", "1"))
+		assert.Contains(t, span.Attributes(), attribute.String("decision", "cancel"))
+		assert.Contains(t, span.Attributes(), attribute.Float64("max_age.seconds", 3600))
+	})
+
+	t.Run("RecordsKeepDecisionOnSpanIfWithinMaxAge", func(t *testing.T) {
+
+		s := &service{
+			config: Config{
+				BuildRules: []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceInserted}},
+			},
+		}
+		build := &contracts.Build{ID: "2", BuildStatus: "running", InsertedAt: time.Now().Add(-time.Minute)}
+
+		// act
+		err := s.evaluateBuild(context.Background(), &corev1.CycleReport{DryRun: true}, build)
+
+		assert.Nil(t, err)
+		assert.Contains(t, lastEndedSpan(spanRecorder).Attributes(), attribute.String("decision", "keep"))
+	})
+
+	t.Run("RecordsErrorOnSpan", func(t *testing.T) {
+
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		s := &service{
+			estafetteciapiClient: &fakeEstafetteciapiClient{
+				cancelBuild: func(ctx context.Context, build *contracts.Build) error {
+					return errors.New("api unavailable")
+				},
+			},
+			auditService: auditService,
+			config: Config{
+				BuildRules: []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceInserted}},
+			},
+		}
+		build := &contracts.Build{ID: "3", BuildStatus: "running", InsertedAt: time.Now().Add(-2 * time.Hour)}
+
+		// act
+		err := s.evaluateBuild(context.Background(), &corev1.CycleReport{}, build)
+
+		assert.NotNil(t, err)
+		span := lastEndedSpan(spanRecorder)
+		assert.Equal(t, codes.Error, span.Status().Code)
+		assert.Contains(t, span.Attributes(), attribute.String("result", "failed"))
+	})
+}
+
+func TestEscalateBuild(t *testing.T) {
+	t.Run("ForceDeletesJobOfBuildStuckInCanceling", func(t *testing.T) {
+
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		forceDeleted := []string{}
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJob: func(ctx context.Context, jobName string) (*batchv1.Job, error) {
+					return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: jobName}}, nil
+				},
+				forceDeleteJob: func(ctx context.Context, jobName string) error {
+					forceDeleted = append(forceDeleted, jobName)
+					return nil
+				},
+			},
+			auditService: auditService,
+			config: Config{
+				BuildRules: []StatusRule{{Status: "canceling", MaxAge: 5 * time.Minute, AgeReference: AgeReferenceUpdated}},
+			},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "canceling", UpdatedAt: time.Now().Add(-time.Hour)}
+		report := &corev1.CycleReport{StartedAt: time.Now().UTC()}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"build-estafette-ci-api-1"}, forceDeleted)
+		if assert.Equal(t, 1, len(report.Actions)) {
+			assert.Equal(t, "force-delete", report.Actions[0].Action)
+			assert.Equal(t, "succeeded", report.Actions[0].Result)
+		}
+	})
+
+	t.Run("RecordsFailedForceDeleteOfBuildStuckInCanceling", func(t *testing.T) {
+
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJob: func(ctx context.Context, jobName string) (*batchv1.Job, error) {
+					return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: jobName}}, nil
+				},
+				forceDeleteJob: func(ctx context.Context, jobName string) error {
+					return errors.New("api unavailable")
+				},
+			},
+			auditService: auditService,
+			config: Config{
+				BuildRules: []StatusRule{{Status: "canceling", MaxAge: 5 * time.Minute, AgeReference: AgeReferenceUpdated}},
+			},
+		}
+		build := &contracts.Build{ID: "3", RepoName: "estafette-ci-api", BuildStatus: "canceling", UpdatedAt: time.Now().Add(-time.Hour)}
+		report := &corev1.CycleReport{StartedAt: time.Now().UTC()}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.NotNil(t, err)
+		if assert.Equal(t, 1, len(report.Actions)) {
+			assert.Equal(t, "force-delete", report.Actions[0].Action)
+			assert.Equal(t, "failed", report.Actions[0].Result)
+			assert.Equal(t, "api unavailable", report.Actions[0].Error)
+		}
+	})
+
+	t.Run("SkipsBuildStuckInCancelingOnceItsJobIsGone", func(t *testing.T) {
+
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJob: func(ctx context.Context, jobName string) (*batchv1.Job, error) {
+					return nil, nil
+				},
+				forceDeleteJob: func(ctx context.Context, jobName string) error {
+					t.Errorf("job %v got force deleted again", jobName)
+					return nil
+				},
+			},
+			auditService: auditService,
+			config: Config{
+				BuildRules: []StatusRule{{Status: "canceling", MaxAge: 5 * time.Minute, AgeReference: AgeReferenceUpdated}},
+			},
+		}
+		build := &contracts.Build{ID: "2", RepoName: "estafette-ci-api", BuildStatus: "canceling", UpdatedAt: time.Now().Add(-time.Hour)}
+		report := &corev1.CycleReport{StartedAt: time.Now().UTC()}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(report.Actions))
+		assert.Contains(t, lastEndedSpan(spanRecorder).Attributes(), attribute.String("decision", "skip-job-gone"))
+	})
+}
+
+func TestWarnBeforeCancel(t *testing.T) {
+	t.Run("WarnsFirstAndCancelsInNextCycle", func(t *testing.T) {
+
+		canceled := 0
+		annotations := map[string]string{}
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		webhookClient, _ := webhook.NewClient("", time.Minute)
+		s := &service{
+			estafetteciapiClient: &fakeEstafetteciapiClient{
+				cancelBuild: func(ctx context.Context, build *contracts.Build) error {
+					canceled++
+					return nil
+				},
+			},
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJob: func(ctx context.Context, jobName string) (*batchv1.Job, error) {
+					return nil, nil
+				},
+				annotateJob: func(ctx context.Context, jobName string, a map[string]string) error {
+					annotations = a
+					return nil
+				},
+			},
+			webhookClient: webhookClient,
+			auditService:  auditService,
+			config: Config{
+				BuildRules:       []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceInserted}},
+				WarnBeforeCancel: true,
+			},
+			warnings: map[string]time.Time{},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: time.Now().Add(-2 * time.Hour)}
+
+		// act
+		firstReport := cleanBuild(t, s, build)
+		secondReport := cleanBuild(t, s, build)
+
+		assert.Equal(t, "warn", firstReport.Actions[0].Action)
+		assert.Contains(t, annotations, cancelWarningAnnotation)
+		assert.Equal(t, "cancel", secondReport.Actions[0].Action)
+		assert.Equal(t, 1, canceled)
+	})
+
+	t.Run("WarnsAgainIfBuildGotBackWithinItsLimitsBeforeCrossingThemAgain", func(t *testing.T) {
+
+		canceled := 0
+		annotations := map[string]string{}
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		webhookClient, _ := webhook.NewClient("", time.Minute)
+		s := &service{
+			estafetteciapiClient: &fakeEstafetteciapiClient{
+				cancelBuild: func(ctx context.Context, build *contracts.Build) error {
+					canceled++
+					return nil
+				},
+			},
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJob: func(ctx context.Context, jobName string) (*batchv1.Job, error) {
+					job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: jobName, Annotations: map[string]string{}}}
+					for key, value := range annotations {
+						job.Annotations[key] = value
+					}
+					return job, nil
+				},
+				annotateJob: func(ctx context.Context, jobName string, a map[string]string) error {
+					for key, value := range a {
+						annotations[key] = value
+					}
+					return nil
+				},
+			},
+			webhookClient: webhookClient,
+			auditService:  auditService,
+			config: Config{
+				BuildRules:       []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceInserted}},
+				WarnBeforeCancel: true,
+			},
+			warnings: map[string]time.Time{},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: time.Now().Add(-2 * time.Hour)}
+
+		// act
+		warnReport := cleanBuild(t, s, build)
+		// a config reload raises the max age, so the build is back within its limits
+		s.config.BuildRules[0].MaxAge = 3 * time.Hour
+		keepReport := cleanBuild(t, s, build)
+		s.config.BuildRules[0].MaxAge = time.Hour
+		// a restart loses the warnings in memory, leaving the annotation
+		s.warnings = map[string]time.Time{}
+		secondWarnReport := cleanBuild(t, s, build)
+
+		assert.Equal(t, "warn", warnReport.Actions[0].Action)
+		assert.Empty(t, keepReport.Actions)
+		if assert.Equal(t, 1, len(secondWarnReport.Actions)) {
+			assert.Equal(t, "warn", secondWarnReport.Actions[0].Action)
+		}
+		assert.Equal(t, 0, canceled)
+	})
+
+	t.Run("CancelsPendingBuildWithoutJobAfterRestart", func(t *testing.T) {
+
+		canceled := 0
+		warningConfigMap := map[string]string{}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "pending", InsertedAt: time.Now().Add(-2 * time.Hour)}
+		// every cycle runs in a new service, like it does when running a single cycle per cronjob run
+		newService := func() *service {
+			auditService, _ := audit.NewService(nil, "", "", 0)
+			webhookClient, _ := webhook.NewClient("", time.Minute)
+			return &service{
+				estafetteciapiClient: &fakeEstafetteciapiClient{
+					getBuilds: func(ctx context.Context, statuses []string, pageNumber, pageSize int) (corev1.PagedBuildResponse, error) {
+						return corev1.PagedBuildResponse{Items: []*contracts.Build{build}, Pagination: contracts.Pagination{Page: 1, Size: pageSize, TotalPages: 1}}, nil
+					},
+					cancelBuild: func(ctx context.Context, build *contracts.Build) error {
+						canceled++
+						return nil
+					},
+				},
+				kubernetesapiClient: &fakeKubernetesapiClient{
+					getJob: func(ctx context.Context, jobName string) (*batchv1.Job, error) {
+						return nil, nil
+					},
+					annotateJob: func(ctx context.Context, jobName string, annotations map[string]string) error {
+						return nil
+					},
+					getConfigMapData: func(ctx context.Context, name string) (map[string]string, error) {
+						return warningConfigMap, nil
+					},
+					setConfigMapData: func(ctx context.Context, name string, data map[string]string) error {
+						warningConfigMap = data
+						return nil
+					},
+				},
+				webhookClient: webhookClient,
+				auditService:  auditService,
+				config: Config{
+					BuildRules:       []StatusRule{{Status: "pending", MaxAge: time.Hour, AgeReference: AgeReferenceInserted}},
+					WarnBeforeCancel: true,
+					WarningConfigMap: "estafette-ci-hanging-job-cleaner-warnings",
+					Watch:            true,
+				},
+				warnings: map[string]time.Time{},
+			}
+		}
+
+		// act
+		firstReport, err := newService().clean(context.Background(), false)
+		assert.Nil(t, err)
+		secondReport, err := newService().clean(context.Background(), false)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "warn", firstReport.Actions[0].Action)
+		assert.Contains(t, warningConfigMap, "build-1")
+		assert.Equal(t, "cancel", secondReport.Actions[0].Action)
+		assert.Equal(t, 1, canceled)
+	})
+
+	t.Run("SkipsBuildsWithProtectedJob", func(t *testing.T) {
+
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJob: func(ctx context.Context, jobName string) (*batchv1.Job, error) {
+					return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: jobName, Annotations: map[string]string{protectAnnotation: "true"}}}, nil
+				},
+			},
+			config: Config{
+				BuildRules:       []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceInserted}},
+				WarnBeforeCancel: true,
+			},
+			warnings: map[string]time.Time{"build-1": time.Now().Add(-time.Hour)},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: time.Now().Add(-2 * time.Hour)}
+		report := &corev1.CycleReport{StartedAt: time.Now().UTC(), DryRun: true}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Empty(t, report.Actions)
+	})
+
+	t.Run("CancelsIfJobGotWarnedBeforeRestart", func(t *testing.T) {
+
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJob: func(ctx context.Context, jobName string) (*batchv1.Job, error) {
+					warnedAt := time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339)
+					return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: jobName, Annotations: map[string]string{cancelWarningAnnotation: warnedAt}}}, nil
+				},
+			},
+			config: Config{
+				BuildRules:       []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceInserted}},
+				WarnBeforeCancel: true,
+			},
+			warnings: map[string]time.Time{},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: time.Now().Add(-2 * time.Hour)}
+		report := &corev1.CycleReport{StartedAt: time.Now().UTC(), DryRun: true}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "cancel", report.Actions[0].Action)
+	})
+}
+
+func TestDetectLogInactivity(t *testing.T) {
+	t.Run("CancelsRunningBuildWithinMaxAgeWithoutLogOutput", func(t *testing.T) {
+
+		startedAt := time.Now().Add(-time.Hour)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde"}, Status: v1.PodStatus{Phase: v1.PodRunning}}}, nil
+				},
+				getLastLogTime: func(ctx context.Context, pod v1.Pod, since time.Duration) (*time.Time, error) {
+					return nil, nil
+				},
+			},
+			config: Config{
+				BuildRules:           []StatusRule{{Status: "running", MaxAge: 6 * time.Hour, AgeReference: AgeReferenceStarted}},
+				LogInactivityTimeout: 15 * time.Minute,
+			},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: startedAt, StartedAt: &startedAt}
+		report := &corev1.CycleReport{DryRun: true}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "cancel", report.Actions[0].Action)
+		assert.Equal(t, "build-log-inactivity", report.Actions[0].Rule)
+		assert.Equal(t, ageReferenceLastLogOutput, report.Actions[0].AgeReference)
+		assert.Equal(t, 15*time.Minute, report.Actions[0].MaxAge)
+	})
+
+	t.Run("KeepsRunningBuildWithRecentLogOutput", func(t *testing.T) {
+
+		startedAt := time.Now().Add(-time.Hour)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde"}, Status: v1.PodStatus{Phase: v1.PodRunning}}}, nil
+				},
+				getLastLogTime: func(ctx context.Context, pod v1.Pod, since time.Duration) (*time.Time, error) {
+					lastLogTime := time.Now().Add(-time.Minute)
+					return &lastLogTime, nil
+				},
+			},
+			config: Config{
+				BuildRules:           []StatusRule{{Status: "running", MaxAge: 6 * time.Hour, AgeReference: AgeReferenceStarted}},
+				LogInactivityTimeout: 15 * time.Minute,
+			},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: startedAt, StartedAt: &startedAt}
+		report := &corev1.CycleReport{DryRun: true}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Empty(t, report.Actions)
+	})
+
+	t.Run("KeepsRunningBuildWithoutRunningPod", func(t *testing.T) {
+
+		startedAt := time.Now().Add(-time.Hour)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde"}, Status: v1.PodStatus{Phase: v1.PodPending}}}, nil
+				},
+			},
+			config: Config{
+				BuildRules:           []StatusRule{{Status: "running", MaxAge: 6 * time.Hour, AgeReference: AgeReferenceStarted}},
+				LogInactivityTimeout: 15 * time.Minute,
+			},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: startedAt, StartedAt: &startedAt}
+		report := &corev1.CycleReport{DryRun: true}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Empty(t, report.Actions)
+	})
+}
+
+func TestLastLogActivity(t *testing.T) {
+	t.Run("KeepsRunningBuildBeyondMaxAgeThatIsStillLogging", func(t *testing.T) {
+
+		lastLogTime := time.Now().Add(-10 * time.Minute)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde"}, Status: v1.PodStatus{Phase: v1.PodRunning}}}, nil
+				},
+				getLastLogTime: func(ctx context.Context, pod v1.Pod, since time.Duration) (*time.Time, error) {
+					return &lastLogTime, nil
+				},
+			},
+			config: Config{
+				BuildRules: []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceLastLogActivity}},
+			},
+		}
+		startedAt := time.Now().Add(-2 * time.Hour)
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: startedAt, StartedAt: &startedAt}
+
+		// act
+		err := s.evaluateBuild(context.Background(), &corev1.CycleReport{DryRun: true}, build)
+
+		assert.Nil(t, err)
+		span := lastEndedSpan(spanRecorder)
+		assert.Contains(t, span.Attributes(), attribute.String("decision", "keep"))
+		assert.Contains(t, span.Attributes(), attribute.String("age.reference_time", lastLogTime.UTC().Format(time.RFC3339)))
+	})
+
+	t.Run("CancelsRunningBuildThatStoppedLoggingBeyondMaxAge", func(t *testing.T) {
+
+		lastLogTime := time.Now().Add(-90 * time.Minute)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde"}, Status: v1.PodStatus{Phase: v1.PodRunning}}}, nil
+				},
+				getLastLogTime: func(ctx context.Context, pod v1.Pod, since time.Duration) (*time.Time, error) {
+					return &lastLogTime, nil
+				},
+			},
+			config: Config{
+				BuildRules: []StatusRule{{Status: "running", MaxAge: time.Hour, AgeReference: AgeReferenceLastLogActivity}},
+			},
+		}
+		startedAt := time.Now().Add(-2 * time.Hour)
+		build := &contracts.Build{ID: "2", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: startedAt, StartedAt: &startedAt}
+		report := &corev1.CycleReport{DryRun: true}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(report.Actions)) {
+			assert.Equal(t, "cancel", report.Actions[0].Action)
+			assert.Equal(t, "last-log-activity", report.Actions[0].AgeReference)
+			assert.Equal(t, lastLogTime.UTC(), report.Actions[0].ReferenceTime)
+		}
+	})
+
+	t.Run("FallsBackToStartedWithoutRunningPod", func(t *testing.T) {
+
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{}, nil
+				},
+			},
+		}
+		startedAt := time.Now().Add(-2 * time.Hour)
+
+		// act
+		lastActivity := s.lastLogActivity(context.Background(), "build-estafette-ci-api-3", startedAt)
+
+		assert.Equal(t, startedAt, lastActivity)
+	})
+}
+
+func TestEvaluateLostNode(t *testing.T) {
+	t.Run("ForceDeletesJobAndCancelsBuildOnNotReadyNode", func(t *testing.T) {
+
+		startedAt := time.Now().Add(-time.Hour)
+		forceDeleted := []string{}
+		canceled := 0
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		s := &service{
+			estafetteciapiClient: &fakeEstafetteciapiClient{
+				cancelBuild: func(ctx context.Context, build *contracts.Build) error {
+					canceled++
+					return nil
+				},
+			},
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde"}, Spec: v1.PodSpec{NodeName: "node-1"}}}, nil
+				},
+				getNode: func(ctx context.Context, nodeName string) (*v1.Node, error) {
+					return getNode(nodeName, v1.ConditionUnknown, time.Now().Add(-10*time.Minute)), nil
+				},
+				forceDeleteJob: func(ctx context.Context, jobName string) error {
+					forceDeleted = append(forceDeleted, jobName)
+					return nil
+				},
+			},
+			auditService: auditService,
+			config: Config{
+				BuildRules:          []StatusRule{{Status: "running", MaxAge: 6 * time.Hour, AgeReference: AgeReferenceStarted}},
+				NodeLostGracePeriod: 5 * time.Minute,
+			},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: startedAt, StartedAt: &startedAt}
+		report := &corev1.CycleReport{}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"build-estafette-ci-api-1"}, forceDeleted)
+		assert.Equal(t, 1, canceled)
+		assert.Equal(t, "force-delete", report.Actions[0].Action)
+		assert.Equal(t, "build-node-lost", report.Actions[0].Rule)
+	})
+
+	t.Run("KeepsBuildOnNodeNotReadyWithinGracePeriod", func(t *testing.T) {
+
+		startedAt := time.Now().Add(-time.Hour)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde"}, Spec: v1.PodSpec{NodeName: "node-1"}}}, nil
+				},
+				getNode: func(ctx context.Context, nodeName string) (*v1.Node, error) {
+					return getNode(nodeName, v1.ConditionFalse, time.Now().Add(-time.Minute)), nil
+				},
+			},
+			config: Config{
+				BuildRules:          []StatusRule{{Status: "running", MaxAge: 6 * time.Hour, AgeReference: AgeReferenceStarted}},
+				NodeLostGracePeriod: 5 * time.Minute,
+			},
+		}
+		build := &contracts.Build{ID: "1", RepoName: "estafette-ci-api", BuildStatus: "running", InsertedAt: startedAt, StartedAt: &startedAt}
+		report := &corev1.CycleReport{DryRun: true}
+
+		// act
+		err := s.evaluateBuild(context.Background(), report, build)
+
+		assert.Nil(t, err)
+		assert.Empty(t, report.Actions)
+	})
+
+	t.Run("ForceDeletesJobOfReleaseOnDeletedNode", func(t *testing.T) {
+
+		startedAt := time.Now().Add(-time.Hour)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				getJobPods: func(ctx context.Context, jobName string) ([]v1.Pod, error) {
+					return []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde", CreationTimestamp: metav1.NewTime(startedAt)}, Spec: v1.PodSpec{NodeName: "node-1"}}}, nil
+				},
+				getNode: func(ctx context.Context, nodeName string) (*v1.Node, error) {
+					return nil, nil
+				},
+			},
+			config: Config{
+				ReleaseRules:        []StatusRule{{Status: "running", MaxAge: 6 * time.Hour, AgeReference: AgeReferenceStarted}},
+				NodeLostGracePeriod: 5 * time.Minute,
+			},
+		}
+		release := &contracts.Release{ID: "2", RepoName: "estafette-ci-api", ReleaseStatus: "running", InsertedAt: &startedAt, StartedAt: &startedAt}
+		report := &corev1.CycleReport{DryRun: true}
+
+		// act
+		err := s.evaluateRelease(context.Background(), report, release)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "force-delete", report.Actions[0].Action)
+		assert.Equal(t, "release-node-lost", report.Actions[0].Rule)
+		assert.Equal(t, "release-estafette-ci-api-2", report.Actions[0].Name)
+	})
+}
+
+func getNode(name string, ready v1.ConditionStatus, lastTransitionTime time.Time) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready, LastTransitionTime: metav1.NewTime(lastTransitionTime)}},
+		},
+	}
+}
+
+func TestEvaluateKubernetesObject(t *testing.T) {
+	t.Run("RecordsDeletionAsSkippedIfPreconditionFails", func(t *testing.T) {
+
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		s := &service{auditService: auditService}
+		meta := metav1.ObjectMeta{Name: "build-estafette-ci-api-1", Namespace: "estafette-ci-jobs", CreationTimestamp: metav1.NewTime(time.Now().Add(-2 * time.Hour))}
+		report := &corev1.CycleReport{}
+
+		// act
+		err := s.evaluateKubernetesObject(context.Background(), report, "job", meta, time.Hour, func(ctx context.Context) error {
+			return fmt.Errorf("job %v in namespace %v: %w", meta.Name, meta.Namespace, kubernetesapi.ErrPreconditionFailed)
+		})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(report.Actions)) {
+			assert.Equal(t, "skipped", report.Actions[0].Result)
+		}
+		assert.Contains(t, lastEndedSpan(spanRecorder).Attributes(), attribute.String("decision", "skip-precondition-failed"))
+	})
+}
+
+func TestEvaluateTerminating(t *testing.T) {
+	t.Run("ReportsStuckObjectWithoutEscalating", func(t *testing.T) {
+
+		s := &service{
+			config: Config{StuckTerminatingThreshold: 15 * time.Minute},
+		}
+		report := &corev1.CycleReport{}
+
+		// act
+		err := s.evaluateKubernetesObject(context.Background(), report, "job", getTerminatingMeta("build-estafette-ci-api-1", 20*time.Minute), kubernetesObjectMaxAge, func(ctx context.Context) error {
+			return errors.New("deleting a terminating object again is pointless")
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "none", report.Actions[0].Action)
+		assert.Equal(t, "skipped", report.Actions[0].Result)
+		assert.Equal(t, "job-stuck-terminating", report.Actions[0].Rule)
+	})
+
+	t.Run("ForceDeletesPodsOfStuckJobFirst", func(t *testing.T) {
+
+		forceDeletedPodsOf := []string{}
+		removedFinalizersOf := []string{}
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				forceDeleteJobPods: func(ctx context.Context, jobName string) error {
+					forceDeletedPodsOf = append(forceDeletedPodsOf, jobName)
+					return nil
+				},
+				removeFinalizers: func(ctx context.Context, kind, name string) error {
+					removedFinalizersOf = append(removedFinalizersOf, name)
+					return nil
+				},
+			},
+			auditService: auditService,
+			config:       Config{StuckTerminatingThreshold: 15 * time.Minute, EscalateStuckTerminating: true},
+		}
+		report := &corev1.CycleReport{}
+
+		// act
+		err := s.evaluateTerminating(context.Background(), report, s.config, "job", getTerminatingMeta("build-estafette-ci-api-1", 20*time.Minute))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "force-delete-dependents", report.Actions[0].Action)
+		assert.Equal(t, []string{"build-estafette-ci-api-1"}, forceDeletedPodsOf)
+		assert.Empty(t, removedFinalizersOf)
+	})
+
+	t.Run("RemovesFinalizersOfJobStillStuckAfterTwiceTheThreshold", func(t *testing.T) {
+
+		removedFinalizersOf := []string{}
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		s := &service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				forceDeleteJobPods: func(ctx context.Context, jobName string) error {
+					return nil
+				},
+				removeFinalizers: func(ctx context.Context, kind, name string) error {
+					removedFinalizersOf = append(removedFinalizersOf, kind+"/"+name)
+					return nil
+				},
+			},
+			auditService: auditService,
+			config:       Config{StuckTerminatingThreshold: 15 * time.Minute, EscalateStuckTerminating: true},
+		}
+		report := &corev1.CycleReport{}
+
+		// act
+		err := s.evaluateTerminating(context.Background(), report, s.config, "job", getTerminatingMeta("build-estafette-ci-api-1", 40*time.Minute))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "remove-finalizers", report.Actions[0].Action)
+		assert.Equal(t, []string{"job/build-estafette-ci-api-1"}, removedFinalizersOf)
+	})
+
+	t.Run("KeepsObjectTerminatingWithinThreshold", func(t *testing.T) {
+
+		s := &service{
+			config: Config{StuckTerminatingThreshold: 15 * time.Minute, EscalateStuckTerminating: true},
+		}
+		report := &corev1.CycleReport{}
+
+		// act
+		err := s.evaluateTerminating(context.Background(), report, s.config, "configmap", getTerminatingMeta("build-estafette-ci-api-1", time.Minute))
+
+		assert.Nil(t, err)
+		assert.Empty(t, report.Actions)
+	})
+}
+
+func TestNextTerminatingCheck(t *testing.T) {
+	t.Run("ReturnsThresholdAfterDeletion", func(t *testing.T) {
+
+		now := time.Now()
+		meta := getTerminatingMeta("build-estafette-ci-api-1", time.Minute)
+		config := Config{StuckTerminatingThreshold: 15 * time.Minute}
+
+		// act
+		next, ok := nextTerminatingCheck(config, "configmap", meta, now)
+
+		assert.True(t, ok)
+		assert.Equal(t, meta.DeletionTimestamp.Time.Add(15*time.Minute), next)
+	})
+
+	t.Run("ReturnsTwiceTheThresholdForEscalatedJob", func(t *testing.T) {
+
+		now := time.Now()
+		meta := getTerminatingMeta("build-estafette-ci-api-1", 20*time.Minute)
+		config := Config{StuckTerminatingThreshold: 15 * time.Minute, EscalateStuckTerminating: true}
+
+		// act
+		next, ok := nextTerminatingCheck(config, "job", meta, now)
+
+		assert.True(t, ok)
+		assert.Equal(t, meta.DeletionTimestamp.Time.Add(30*time.Minute), next)
+	})
+
+	t.Run("ReturnsFalseOnceNothingIsLeftToDo", func(t *testing.T) {
+
+		now := time.Now()
+		meta := getTerminatingMeta("build-estafette-ci-api-1", 20*time.Minute)
+		config := Config{StuckTerminatingThreshold: 15 * time.Minute}
+
+		// act
+		_, ok := nextTerminatingCheck(config, "job", meta, now)
+
+		assert.False(t, ok)
+	})
+}
+
+func getTerminatingMeta(name string, terminatingFor time.Duration) metav1.ObjectMeta {
+	deletionTimestamp := metav1.NewTime(time.Now().Add(-terminatingFor))
+	return metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "estafette-ci-jobs",
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		DeletionTimestamp: &deletionTimestamp,
+		Finalizers:        []string{"foregroundDeletion"},
+	}
+}
+
+// cleanBuild evaluates a build the way a cycle does, carrying warnings over to the next one
+func cleanBuild(t *testing.T, s *service, build *contracts.Build) corev1.CycleReport {
+	report := corev1.CycleReport{StartedAt: time.Now().UTC()}
+	s.nextWarnings = map[string]time.Time{}
+	err := s.evaluateBuild(context.Background(), &report, build)
+	s.rotateWarnings(err)
+	assert.Nil(t, err)
+
+	return report
+}
+
+func lastEndedSpan(spanRecorder *tracetest.SpanRecorder) sdktrace.ReadOnlySpan {
+	spans := spanRecorder.Ended()
+	return spans[len(spans)-1]
+}
+
+// fakeEstafetteciapiClient only implements the methods a test sets, calling any other method panics
+type fakeEstafetteciapiClient struct {
+	estafetteciapi.Client
+	getBuilds   func(ctx context.Context, statuses []string, pageNumber, pageSize int) (corev1.PagedBuildResponse, error)
+	cancelBuild func(ctx context.Context, build *contracts.Build) error
+}
+
+func (c *fakeEstafetteciapiClient) GetBuilds(ctx context.Context, statuses []string, pageNumber, pageSize int) (corev1.PagedBuildResponse, error) {
+	return c.getBuilds(ctx, statuses, pageNumber, pageSize)
+}
+
+func (c *fakeEstafetteciapiClient) CancelBuild(ctx context.Context, build *contracts.Build) error {
+	return c.cancelBuild(ctx, build)
+}
+
+// fakeKubernetesapiClient only implements the methods a test sets, calling any other method panics
+type fakeKubernetesapiClient struct {
+	kubernetesapi.Client
+	getJob             func(ctx context.Context, jobName string) (*batchv1.Job, error)
+	annotateJob        func(ctx context.Context, jobName string, annotations map[string]string) error
+	getJobPods         func(ctx context.Context, jobName string) ([]v1.Pod, error)
+	getLastLogTime     func(ctx context.Context, pod v1.Pod, since time.Duration) (*time.Time, error)
+	getNode            func(ctx context.Context, nodeName string) (*v1.Node, error)
+	forceDeleteJob     func(ctx context.Context, jobName string) error
+	forceDeleteJobPods func(ctx context.Context, jobName string) error
+	removeFinalizers   func(ctx context.Context, kind, name string) error
+	getConfigMapData   func(ctx context.Context, name string) (map[string]string, error)
+	setConfigMapData   func(ctx context.Context, name string, data map[string]string) error
+	deleteConfigMap    func(ctx context.Context, configmap v1.ConfigMap) error
+}
+
+func (c *fakeKubernetesapiClient) GetJob(ctx context.Context, jobName string) (*batchv1.Job, error) {
+	return c.getJob(ctx, jobName)
+}
+
+func (c *fakeKubernetesapiClient) AnnotateJob(ctx context.Context, jobName string, annotations map[string]string) error {
+	return c.annotateJob(ctx, jobName, annotations)
+}
+
+func (c *fakeKubernetesapiClient) GetJobPods(ctx context.Context, jobName string) ([]v1.Pod, error) {
+	return c.getJobPods(ctx, jobName)
+}
+
+func (c *fakeKubernetesapiClient) GetLastLogTime(ctx context.Context, pod v1.Pod, since time.Duration) (*time.Time, error) {
+	return c.getLastLogTime(ctx, pod, since)
+}
+
+func (c *fakeKubernetesapiClient) GetNode(ctx context.Context, nodeName string) (*v1.Node, error) {
+	return c.getNode(ctx, nodeName)
+}
+
+func (c *fakeKubernetesapiClient) ForceDeleteJob(ctx context.Context, jobName string) error {
+	return c.forceDeleteJob(ctx, jobName)
+}
+
+func (c *fakeKubernetesapiClient) ForceDeleteJobPods(ctx context.Context, jobName string) error {
+	return c.forceDeleteJobPods(ctx, jobName)
+}
+
+func (c *fakeKubernetesapiClient) RemoveFinalizers(ctx context.Context, kind, name string) error {
+	return c.removeFinalizers(ctx, kind, name)
+}
+
+func (c *fakeKubernetesapiClient) GetConfigMapData(ctx context.Context, name string) (map[string]string, error) {
+	return c.getConfigMapData(ctx, name)
+}
+
+func (c *fakeKubernetesapiClient) SetConfigMapData(ctx context.Context, name string, data map[string]string) error {
+	return c.setConfigMapData(ctx, name, data)
+}
+
+func (c *fakeKubernetesapiClient) DeleteConfigMap(ctx context.Context, configmap v1.ConfigMap) error {
+	return c.deleteConfigMap(ctx, configmap)
+}
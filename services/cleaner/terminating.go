@@ -0,0 +1,100 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ageReferenceDeletion is the age reference of actions for objects stuck in terminating
+const ageReferenceDeletion = "deletion"
+
+// evaluateTerminating detects a job, pod, configmap or secret that's been terminating for longer than the stuck
+// terminating threshold and records it in the report; if escalation is enabled it first deletes the pods of a job without
+// grace period and, once it's still terminating after twice the threshold, removes its finalizers as a last resort
+func (s *service) evaluateTerminating(ctx context.Context, report *corev1.CycleReport, config Config, kind string, meta metav1.ObjectMeta) (err error) {
+	span := trace.SpanFromContext(ctx)
+
+	threshold := config.StuckTerminatingThreshold
+	if threshold <= 0 || meta.DeletionTimestamp == nil {
+		span.SetAttributes(attribute.String("decision", "skip-terminating"))
+		return nil
+	}
+
+	now := time.Now().UTC()
+	action := corev1.CleanupAction{
+		Time:          now,
+		Kind:          kind,
+		Namespace:     meta.Namespace,
+		Name:          meta.Name,
+		Rule:          fmt.Sprintf("%v-stuck-terminating", kind),
+		AgeReference:  ageReferenceDeletion,
+		ReferenceTime: meta.DeletionTimestamp.Time,
+		Age:           now.Sub(meta.DeletionTimestamp.Time),
+		MaxAge:        threshold,
+	}
+	setAgeAttributes(span, ageReferenceDeletion, action.ReferenceTime, action.Age, action.MaxAge)
+	if action.Age <= threshold {
+		span.SetAttributes(attribute.String("decision", "skip-terminating"))
+		return nil
+	}
+
+	log.Warn().Msgf("The %v %v is terminating for %v since %v, with finalizers %v", kind, meta.Name, action.Age, action.ReferenceTime, meta.Finalizers)
+
+	if !config.EscalateStuckTerminating {
+		// nothing gets touched, but the report still shows what's stuck
+		action.Action = "none"
+		action.Result = "skipped"
+		span.SetAttributes(attribute.String("decision", "skip-stuck-terminating"), attribute.String("rule", action.Rule))
+		report.Actions = append(report.Actions, action)
+		return nil
+	}
+
+	// pods are the only dependents the cleaner knows how to clear, for other kinds removing finalizers is all that's left
+	if kind == "job" && action.Age <= 2*threshold {
+		action.Action = "force-delete-dependents"
+	} else {
+		action.Action = "remove-finalizers"
+	}
+	span.SetAttributes(attribute.String("decision", action.Action), attribute.String("rule", action.Rule))
+
+	if !report.DryRun {
+		if kind == "job" {
+			err = s.kubernetesapiClient.ForceDeleteJobPods(ctx, meta.Name)
+		}
+		if err == nil && action.Action == "remove-finalizers" {
+			err = s.kubernetesapiClient.RemoveFinalizers(ctx, kind, meta.Name)
+		}
+	}
+	s.addAction(ctx, report, action, err)
+
+	return err
+}
+
+// nextTerminatingCheck returns when evaluateTerminating has something new to do for an object, or false if it never will
+func nextTerminatingCheck(config Config, kind string, meta metav1.ObjectMeta, now time.Time) (next time.Time, ok bool) {
+	threshold := config.StuckTerminatingThreshold
+	if threshold <= 0 || meta.DeletionTimestamp == nil {
+		return next, false
+	}
+
+	next = meta.DeletionTimestamp.Time.Add(threshold)
+	if next.After(now) {
+		return next, true
+	}
+
+	if kind == "job" && config.EscalateStuckTerminating {
+		next = meta.DeletionTimestamp.Time.Add(2 * threshold)
+		if next.After(now) {
+			return next, true
+		}
+	}
+
+	return next, false
+}
@@ -0,0 +1,163 @@
+package cleaner
+
+import (
+	"context"
+	"time"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+const (
+	// protectAnnotation on a job with value true keeps its build or release from ever getting canceled
+	protectAnnotation = "estafette.io/cleaner-protect"
+
+	// cancelWarningAnnotation holds the time a job's build or release got warned about, so the warning survives
+	// restarts and leader changes
+	cancelWarningAnnotation = "estafette.io/cleaner-cancel-warning"
+)
+
+// warnBeforeCancel returns true if the build or release of the action got warned about in an earlier cycle and can be
+// canceled now; otherwise it skips protected ones and warns about the others, recording the decision on the span
+func (s *service) warnBeforeCancel(ctx context.Context, report *corev1.CycleReport, action corev1.CleanupAction, jobName string) (cancel bool, err error) {
+	span := trace.SpanFromContext(ctx)
+
+	job, err := s.kubernetesapiClient.GetJob(ctx, jobName)
+	if err != nil {
+		return false, err
+	}
+
+	if job != nil && job.Annotations[protectAnnotation] == "true" {
+		log.Info().Msgf("Skipping %v for pipeline %v with id %v, its job %v is protected", action.Kind, action.Pipeline, action.ID, jobName)
+		span.SetAttributes(attribute.String("decision", "skip-protected"), attribute.String("rule", action.Rule))
+		return false, nil
+	}
+
+	key := warningKey(action.Kind, action.ID)
+	if warnedAt, ok := s.warnedAt(key, job); ok && warnedAt.Before(report.StartedAt) {
+		// keep the warning in case canceling fails and gets retried next cycle
+		if !report.DryRun {
+			s.nextWarnings[key] = warnedAt
+		}
+		return true, nil
+	}
+
+	action.Action = "warn"
+	span.SetAttributes(attribute.String("decision", action.Action), attribute.String("rule", action.Rule))
+	log.Info().Msgf("Warning %v for pipeline %v with id %v, it's %v for %v exceeding max age %v and gets canceled next cycle", action.Kind, action.Pipeline, action.ID, action.Status, action.Age, action.MaxAge)
+	if !report.DryRun {
+		// a failed warning still counts, so a broken webhook doesn't keep builds and releases from ever getting canceled
+		s.nextWarnings[key] = action.Time
+		err = s.warn(ctx, action, jobName)
+	}
+	s.addAction(ctx, report, action, err)
+
+	return false, nil
+}
+
+// warningKey returns the key of a build or release in the warnings and in the warning configmap, which only allows
+// alphanumerics, dashes, dots and underscores in its keys
+func warningKey(kind, id string) string {
+	return kind + "-" + id
+}
+
+// warnedAt returns when a build or release got warned about, either from memory or from the annotation on its job
+func (s *service) warnedAt(key string, job *batchv1.Job) (warnedAt time.Time, ok bool) {
+	if warnedAt, ok = s.warnings[key]; ok {
+		return
+	}
+
+	if job == nil {
+		return
+	}
+
+	warnedAt, err := time.Parse(time.RFC3339, job.Annotations[cancelWarningAnnotation])
+	if err != nil {
+		return warnedAt, false
+	}
+
+	return warnedAt, true
+}
+
+func (s *service) warn(ctx context.Context, action corev1.CleanupAction, jobName string) (err error) {
+	ctx, span := tracer.Start(ctx, "cleaner.Service:warn")
+	defer func() { tracing.End(span, err) }()
+
+	// builds and releases that are still pending have no job to annotate, which is ignored
+	err = s.kubernetesapiClient.AnnotateJob(ctx, jobName, map[string]string{cancelWarningAnnotation: action.Time.Format(time.RFC3339)})
+	if err != nil {
+		return
+	}
+
+	return s.webhookClient.Send(ctx, action)
+}
+
+// clearWarning empties the warning annotation on the job of a build or release that got warned about in the previous
+// cycle but is back within its limits, e.g. because it started logging again or its max age got raised; it's left out
+// of the next warnings either way, so crossing the limits again gets it warned again rather than canceled right away.
+// Failing to clear only gets logged, the annotation then still counts after a restart
+func (s *service) clearWarning(ctx context.Context, report *corev1.CycleReport, kind, id, jobName string) {
+	if report.DryRun {
+		return
+	}
+	if _, ok := s.warnings[warningKey(kind, id)]; !ok {
+		return
+	}
+
+	log.Info().Msgf("The %v with id %v is back within its limits, clearing its warning", kind, id)
+	err := s.kubernetesapiClient.AnnotateJob(ctx, jobName, map[string]string{cancelWarningAnnotation: ""})
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed clearing warning annotation on job %v", jobName)
+	}
+}
+
+// rotateWarnings makes the warnings collected in a cycle the ones the next cycle checks; if the cycle didn't finish,
+// warnings for builds and releases it didn't get to are kept as well
+func (s *service) rotateWarnings(err error) {
+	if err != nil {
+		for key, warnedAt := range s.warnings {
+			if _, ok := s.nextWarnings[key]; !ok {
+				s.nextWarnings[key] = warnedAt
+			}
+		}
+	}
+
+	s.warnings = s.nextWarnings
+	s.nextWarnings = nil
+}
+
+// loadWarnings replaces the warnings with those stored in the warning configmap, so warnings survive restarts of single
+// cycle runs and leader changes even for builds and releases without a job to annotate
+func (s *service) loadWarnings(ctx context.Context) (err error) {
+	data, err := s.kubernetesapiClient.GetConfigMapData(ctx, s.config.WarningConfigMap)
+	if err != nil {
+		return
+	}
+
+	warnings := map[string]time.Time{}
+	for key, value := range data {
+		warnedAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Ignoring warning %v with invalid time %v in configmap %v", key, value, s.config.WarningConfigMap)
+			continue
+		}
+		warnings[key] = warnedAt
+	}
+	s.warnings = warnings
+
+	return nil
+}
+
+// saveWarnings stores the warnings in the warning configmap for the next cycle to load
+func (s *service) saveWarnings(ctx context.Context) (err error) {
+	data := map[string]string{}
+	for key, warnedAt := range s.warnings {
+		data[key] = warnedAt.UTC().Format(time.RFC3339)
+	}
+
+	return s.kubernetesapiClient.SetConfigMapData(ctx, s.config.WarningConfigMap, data)
+}
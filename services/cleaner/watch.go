@@ -0,0 +1,286 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "github.com/estafette/estafette-ci-hanging-job-cleaner/api/core/v1"
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/clients/kubernetesapi"
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Watch schedules a timer for every job, pod, configmap and secret seen by the informers, so each gets deleted the
+// moment it exceeds its max age; it blocks until ctx is done
+func (s *service) Watch(ctx context.Context) (err error) {
+	w := &watcher{
+		service: s,
+		ctx:     ctx,
+		timers:  map[string]*time.Timer{},
+	}
+	defer w.stop()
+
+	return s.kubernetesapiClient.Watch(ctx, w.schedule, w.unschedule)
+}
+
+const (
+	// watchRetryMinDelay and watchRetryMaxDelay bound the backoff between attempts to delete a watched object
+	watchRetryMinDelay = 10 * time.Second
+	watchRetryMaxDelay = 10 * time.Minute
+)
+
+type watcher struct {
+	service *service
+	ctx     context.Context
+
+	mutex  sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func (w *watcher) schedule(object metav1.Object) {
+	kind := objectKind(object)
+	if kind == "" {
+		return
+	}
+
+	// pods owned by a job get deleted along with it, deleting them earlier only makes the job start a new one
+	if pod, ok := object.(*v1.Pod); ok && isOwnedByJob(pod) {
+		return
+	}
+
+	key := objectKey(kind, object)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if timer, ok := w.timers[key]; ok {
+		timer.Stop()
+		delete(w.timers, key)
+	}
+
+	// already being deleted, only check in on it when it could be stuck in terminating
+	if object.GetDeletionTimestamp() != nil {
+		next, ok := nextTerminatingCheck(w.service.currentConfig(), kind, objectMeta(object), time.Now())
+		if !ok {
+			return
+		}
+
+		log.Debug().Msgf("Scheduling stuck terminating check of %v at %v", key, next)
+
+		w.start(key, time.Until(next), func() {
+			w.checkTerminating(kind, key, object)
+		})
+		return
+	}
+
+	deadline := object.GetCreationTimestamp().Time.Add(kubernetesObjectMaxAge)
+
+	log.Debug().Msgf("Scheduling cleanup of %v at %v", key, deadline)
+
+	w.start(key, time.Until(deadline), func() {
+		w.clean(kind, key, object, 0)
+	})
+}
+
+// start runs f after d as the timer of the object with key, unless the timer got replaced or removed by the time it
+// fires; the caller holds the mutex
+func (w *watcher) start(key string, d time.Duration, f func()) {
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		w.mutex.Lock()
+		current := w.timers[key] == timer
+		if current {
+			delete(w.timers, key)
+		}
+		w.mutex.Unlock()
+
+		if current {
+			f()
+		}
+	})
+	w.timers[key] = timer
+}
+
+func (w *watcher) unschedule(object metav1.Object) {
+	kind := objectKind(object)
+	if kind == "" {
+		return
+	}
+
+	key := objectKey(kind, object)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if timer, ok := w.timers[key]; ok {
+		timer.Stop()
+		delete(w.timers, key)
+	}
+}
+
+func (w *watcher) stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for key, timer := range w.timers {
+		timer.Stop()
+		delete(w.timers, key)
+	}
+}
+
+// clean deletes an object that exceeded its max age, retrying with backoff if that fails, since the informers don't
+// resync and no cycle lists the object anymore
+func (w *watcher) clean(kind, key string, object metav1.Object, attempt int) {
+	var err error
+	ctx, span := tracer.Start(w.ctx, "cleaner.Service:watchClean", trace.WithNewRoot(), trace.WithLinks(trace.LinkFromContext(w.ctx)), trace.WithAttributes(
+		attribute.String("kind", kind),
+		attribute.String("namespace", object.GetNamespace()),
+		attribute.String("name", object.GetName()),
+		attribute.Int("attempt", attempt),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	switch o := object.(type) {
+	case *batchv1.Job:
+		err = w.service.kubernetesapiClient.DeleteJob(ctx, *o)
+	case *v1.Pod:
+		err = w.service.kubernetesapiClient.DeletePod(ctx, *o)
+	case *v1.ConfigMap:
+		err = w.service.kubernetesapiClient.DeleteConfigMap(ctx, *o)
+	case *v1.Secret:
+		err = w.service.kubernetesapiClient.DeleteSecret(ctx, *o)
+	}
+	if k8serrors.IsNotFound(err) {
+		log.Debug().Msgf("Skipping cleanup of %v, it's gone already", key)
+		err = nil
+		return
+	}
+
+	action := kubernetesAction(time.Now().UTC(), kind, objectMeta(object), kubernetesObjectMaxAge)
+	setAgeAttributes(span, AgeReference(action.AgeReference), action.ReferenceTime, action.Age, action.MaxAge)
+	span.SetAttributes(attribute.String("decision", action.Action), attribute.String("rule", action.Rule))
+	w.service.addAction(ctx, &corev1.CycleReport{}, action, err)
+
+	// an object that changed since it was seen gets rescheduled by the informer event for that change
+	if err == nil || errors.Is(err, kubernetesapi.ErrPreconditionFailed) {
+		return
+	}
+
+	delay := watchRetryDelay(attempt)
+	log.Error().Err(err).Msgf("Failed cleaning %v, retrying in %v", key, delay)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// an informer event in the meantime has scheduled a cleanup of its own, or watching stopped
+	if _, ok := w.timers[key]; ok || w.ctx.Err() != nil {
+		return
+	}
+	w.start(key, delay, func() {
+		w.clean(kind, key, object, attempt+1)
+	})
+}
+
+// watchRetryDelay returns the time to wait before attempt+1 to delete an object, doubling from watchRetryMinDelay up to
+// watchRetryMaxDelay
+func watchRetryDelay(attempt int) time.Duration {
+	delay := watchRetryMinDelay
+	for i := 0; i < attempt && delay < watchRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > watchRetryMaxDelay {
+		delay = watchRetryMaxDelay
+	}
+
+	return delay
+}
+
+// checkTerminating escalates an object stuck in terminating and schedules the next check, if escalating further is
+// still possible
+func (w *watcher) checkTerminating(kind, key string, object metav1.Object) {
+	var err error
+	ctx, span := tracer.Start(w.ctx, "cleaner.Service:watchTerminating", trace.WithNewRoot(), trace.WithLinks(trace.LinkFromContext(w.ctx)), trace.WithAttributes(
+		attribute.String("kind", kind),
+		attribute.String("namespace", object.GetNamespace()),
+		attribute.String("name", object.GetName()),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	config := w.service.currentConfig()
+	err = w.service.evaluateTerminating(ctx, &corev1.CycleReport{}, config, kind, objectMeta(object))
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed escalating stuck terminating %v", key)
+	}
+
+	next, ok := nextTerminatingCheck(config, kind, objectMeta(object), time.Now())
+	if !ok {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// an informer event in the meantime has scheduled a check of its own
+	if _, ok := w.timers[key]; ok || w.ctx.Err() != nil {
+		return
+	}
+	w.start(key, time.Until(next), func() {
+		w.checkTerminating(kind, key, object)
+	})
+}
+
+func objectMeta(object metav1.Object) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Namespace:         object.GetNamespace(),
+		Name:              object.GetName(),
+		CreationTimestamp: object.GetCreationTimestamp(),
+		DeletionTimestamp: object.GetDeletionTimestamp(),
+		Finalizers:        object.GetFinalizers(),
+	}
+}
+
+func objectKind(object metav1.Object) string {
+	switch object.(type) {
+	case *batchv1.Job:
+		return "job"
+	case *v1.Pod:
+		return "pod"
+	case *v1.ConfigMap:
+		return "configmap"
+	case *v1.Secret:
+		return "secret"
+	}
+
+	return ""
+}
+
+func objectKey(kind string, object metav1.Object) string {
+	return fmt.Sprintf("%v %v/%v", kind, object.GetNamespace(), object.GetName())
+}
+
+func isOwnedByJob(pod *v1.Pod) bool {
+	for _, o := range pod.OwnerReferences {
+		if o.Kind == "Job" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,255 @@
+package cleaner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/estafette/estafette-ci-hanging-job-cleaner/services/audit"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWatchSchedule(t *testing.T) {
+	t.Run("SchedulesCleanupOfObject", func(t *testing.T) {
+
+		w := newWatcher(&service{})
+		defer w.stop()
+
+		// act
+		w.schedule(getWatchedConfigMap("build-1", time.Now()))
+
+		assert.Equal(t, []string{"configmap estafette-ci-jobs/build-1"}, w.keys())
+	})
+
+	t.Run("ReplacesTimerOfUpdatedObject", func(t *testing.T) {
+
+		w := newWatcher(&service{})
+		defer w.stop()
+		w.schedule(getWatchedConfigMap("build-1", time.Now()))
+		previous := w.timers["configmap estafette-ci-jobs/build-1"]
+
+		// act
+		w.schedule(getWatchedConfigMap("build-1", time.Now()))
+
+		assert.Equal(t, []string{"configmap estafette-ci-jobs/build-1"}, w.keys())
+		assert.NotSame(t, previous, w.timers["configmap estafette-ci-jobs/build-1"])
+		assert.False(t, previous.Stop(), "the timer of the previous version should have been stopped")
+	})
+
+	t.Run("SkipsPodsOwnedByJob", func(t *testing.T) {
+
+		w := newWatcher(&service{})
+		defer w.stop()
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              "build-1-abcde",
+			Namespace:         "estafette-ci-jobs",
+			CreationTimestamp: metav1.NewTime(time.Now()),
+			OwnerReferences:   []metav1.OwnerReference{{Kind: "Job", Name: "build-1"}},
+		}}
+
+		// act
+		w.schedule(pod)
+
+		assert.Empty(t, w.keys())
+	})
+
+	t.Run("SchedulesPodsWithoutJob", func(t *testing.T) {
+
+		w := newWatcher(&service{})
+		defer w.stop()
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              "build-1-abcde",
+			Namespace:         "estafette-ci-jobs",
+			CreationTimestamp: metav1.NewTime(time.Now()),
+		}}
+
+		// act
+		w.schedule(pod)
+
+		assert.Equal(t, []string{"pod estafette-ci-jobs/build-1-abcde"}, w.keys())
+	})
+
+	t.Run("SchedulesStuckTerminatingCheckOfObjectBeingDeleted", func(t *testing.T) {
+
+		w := newWatcher(&service{config: Config{StuckTerminatingThreshold: 15 * time.Minute}})
+		defer w.stop()
+		meta := getTerminatingMeta("build-1", time.Minute)
+
+		// act
+		w.schedule(&batchv1.Job{ObjectMeta: meta})
+
+		assert.Equal(t, []string{"job estafette-ci-jobs/build-1"}, w.keys())
+	})
+
+	t.Run("SkipsObjectBeingDeletedWithoutStuckTerminatingThreshold", func(t *testing.T) {
+
+		w := newWatcher(&service{})
+		defer w.stop()
+		meta := getTerminatingMeta("build-1", time.Minute)
+
+		// act
+		w.schedule(&batchv1.Job{ObjectMeta: meta})
+
+		assert.Empty(t, w.keys())
+	})
+
+	t.Run("DeletesObjectOnceItExceedsMaxAge", func(t *testing.T) {
+
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		deleted := make(chan string, 1)
+		w := newWatcher(&service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				deleteConfigMap: func(ctx context.Context, configmap v1.ConfigMap) error {
+					deleted <- configmap.Name
+					return nil
+				},
+			},
+			auditService: auditService,
+		})
+		defer w.stop()
+
+		// act
+		w.schedule(getWatchedConfigMap("build-1", time.Now().Add(-kubernetesObjectMaxAge-time.Minute)))
+
+		select {
+		case name := <-deleted:
+			assert.Equal(t, "build-1", name)
+		case <-time.After(5 * time.Second):
+			t.Fatal("configmap didn't get deleted")
+		}
+	})
+}
+
+func TestWatchClean(t *testing.T) {
+	t.Run("ReschedulesFailedDelete", func(t *testing.T) {
+
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		w := newWatcher(&service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				deleteConfigMap: func(ctx context.Context, configmap v1.ConfigMap) error {
+					return k8serrors.NewTooManyRequests("throttled", 1)
+				},
+			},
+			auditService: auditService,
+		})
+		defer w.stop()
+
+		// act
+		w.clean("configmap", "configmap estafette-ci-jobs/build-1", getWatchedConfigMap("build-1", time.Now().Add(-kubernetesObjectMaxAge-time.Minute)), 0)
+
+		assert.Equal(t, []string{"configmap estafette-ci-jobs/build-1"}, w.keys())
+	})
+
+	t.Run("DoesNotRescheduleObjectThatIsGone", func(t *testing.T) {
+
+		auditService, _ := audit.NewService(nil, "", "", 0)
+		w := newWatcher(&service{
+			kubernetesapiClient: &fakeKubernetesapiClient{
+				deleteConfigMap: func(ctx context.Context, configmap v1.ConfigMap) error {
+					return k8serrors.NewNotFound(v1.Resource("configmaps"), configmap.Name)
+				},
+			},
+			auditService: auditService,
+		})
+		defer w.stop()
+
+		// act
+		w.clean("configmap", "configmap estafette-ci-jobs/build-1", getWatchedConfigMap("build-1", time.Now().Add(-kubernetesObjectMaxAge-time.Minute)), 0)
+
+		assert.Empty(t, w.keys())
+	})
+}
+
+func TestWatchStart(t *testing.T) {
+	t.Run("SkipsTimerReplacedBeforeItFired", func(t *testing.T) {
+
+		w := newWatcher(&service{})
+		defer w.stop()
+		fired := make(chan struct{}, 1)
+
+		// act
+		w.mutex.Lock()
+		w.start("configmap estafette-ci-jobs/build-1", 0, func() { fired <- struct{}{} })
+		w.start("configmap estafette-ci-jobs/build-1", time.Hour, func() {})
+		w.mutex.Unlock()
+
+		select {
+		case <-fired:
+			t.Fatal("the replaced timer ran")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.Equal(t, []string{"configmap estafette-ci-jobs/build-1"}, w.keys())
+	})
+}
+
+func TestWatchRetryDelay(t *testing.T) {
+	t.Run("DoublesUpToMaxDelay", func(t *testing.T) {
+
+		// act
+		delays := []time.Duration{watchRetryDelay(0), watchRetryDelay(1), watchRetryDelay(2), watchRetryDelay(100)}
+
+		assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 10 * time.Minute}, delays)
+	})
+}
+
+func TestWatchUnschedule(t *testing.T) {
+	t.Run("StopsTimerOfDeletedObject", func(t *testing.T) {
+
+		w := newWatcher(&service{})
+		defer w.stop()
+		configmap := getWatchedConfigMap("build-1", time.Now())
+		w.schedule(configmap)
+		timer := w.timers["configmap estafette-ci-jobs/build-1"]
+
+		// act
+		w.unschedule(configmap)
+
+		assert.Empty(t, w.keys())
+		assert.False(t, timer.Stop(), "the timer should have been stopped")
+	})
+
+	t.Run("IgnoresObjectWithoutTimer", func(t *testing.T) {
+
+		w := newWatcher(&service{})
+		defer w.stop()
+		w.schedule(getWatchedConfigMap("build-1", time.Now()))
+
+		// act
+		w.unschedule(getWatchedConfigMap("build-2", time.Now()))
+
+		assert.Equal(t, []string{"configmap estafette-ci-jobs/build-1"}, w.keys())
+	})
+}
+
+func newWatcher(s *service) *watcher {
+	return &watcher{
+		service: s,
+		ctx:     context.Background(),
+		timers:  map[string]*time.Timer{},
+	}
+}
+
+// keys returns the keys of the objects with a timer
+func (w *watcher) keys() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	keys := []string{}
+	for key := range w.timers {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func getWatchedConfigMap(name string, createdAt time.Time) *v1.ConfigMap {
+	return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "estafette-ci-jobs",
+		CreationTimestamp: metav1.NewTime(createdAt),
+	}}
+}
@@ -0,0 +1,104 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Service interface {
+	SetReady(ready bool)
+	SetLeading(leading bool)
+	IsLeading() bool
+	CycleCompleted()
+	RegisterHandlers(mux *http.ServeMux)
+}
+
+// NewService returns a new health.Service, which considers the cleaner wedged if no cycle completed within livenessMultiplier times the interval
+func NewService(interval time.Duration, livenessMultiplier int) (Service, error) {
+	return &service{
+		interval:           interval,
+		livenessMultiplier: livenessMultiplier,
+		leading:            true,
+		lastCycleAt:        time.Now().UTC(),
+	}, nil
+}
+
+type service struct {
+	interval           time.Duration
+	livenessMultiplier int
+
+	mutex       sync.RWMutex
+	ready       bool
+	leading     bool
+	lastCycleAt time.Time
+}
+
+func (s *service) SetReady(ready bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ready = ready
+}
+
+func (s *service) SetLeading(leading bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// a replica that just got elected hasn't had the chance to complete a cycle yet
+	if leading && !s.leading {
+		s.lastCycleAt = time.Now().UTC()
+	}
+	s.leading = leading
+}
+
+func (s *service) IsLeading() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.leading
+}
+
+func (s *service) CycleCompleted() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastCycleAt = time.Now().UTC()
+}
+
+func (s *service) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+}
+
+func (s *service) healthz(w http.ResponseWriter, _ *http.Request) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// only the leader runs cycles, standby replicas are healthy as long as they respond
+	if s.leading && s.interval > 0 {
+		sinceLastCycle := time.Now().UTC().Sub(s.lastCycleAt)
+		if sinceLastCycle > time.Duration(s.livenessMultiplier)*s.interval {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, fmt.Sprintf("No cycle completed in %v\n", sinceLastCycle))
+			return
+		}
+	}
+
+	io.WriteString(w, "I'm alive!\n")
+}
+
+func (s *service) readyz(w http.ResponseWriter, _ *http.Request) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "Not initialized\n")
+		return
+	}
+
+	io.WriteString(w, "I'm ready!\n")
+}
@@ -0,0 +1,181 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	t.Run("ReturnsOkIfNotYetRun", func(t *testing.T) {
+
+		service := getTestService(t)
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/healthz")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("ReturnsOkIfCycleCompletedRecently", func(t *testing.T) {
+
+		service := getTestService(t)
+		service.lastCycleAt = time.Now().UTC().Add(-4 * time.Minute)
+		service.CycleCompleted()
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/healthz")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("ReturnsServiceUnavailableIfNoCycleCompletedWithinLivenessMultiplierTimesInterval", func(t *testing.T) {
+
+		service := getTestService(t)
+		service.lastCycleAt = time.Now().UTC().Add(-4 * time.Minute)
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/healthz")
+
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	})
+
+	t.Run("ReturnsOkIfStaleButNotLeading", func(t *testing.T) {
+
+		service := getTestService(t)
+		service.SetLeading(false)
+		service.lastCycleAt = time.Now().UTC().Add(-4 * time.Minute)
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/healthz")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("ReturnsOkIfStaleWhenGettingElected", func(t *testing.T) {
+
+		service := getTestService(t)
+		service.SetLeading(false)
+		service.lastCycleAt = time.Now().UTC().Add(-4 * time.Minute)
+		service.SetLeading(true)
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/healthz")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("ReturnsOkIfStaleWithoutInterval", func(t *testing.T) {
+
+		service := getTestService(t)
+		service.interval = 0
+		service.lastCycleAt = time.Now().UTC().Add(-4 * time.Minute)
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/healthz")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestReadyz(t *testing.T) {
+	t.Run("ReturnsServiceUnavailableIfNotReady", func(t *testing.T) {
+
+		service := getTestService(t)
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/readyz")
+
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	})
+
+	t.Run("ReturnsOkIfReady", func(t *testing.T) {
+
+		service := getTestService(t)
+		service.SetReady(true)
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/readyz")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("ReturnsOkIfReadyButNotLeading", func(t *testing.T) {
+
+		service := getTestService(t)
+		service.SetReady(true)
+		service.SetLeading(false)
+		server := getTestServer(service)
+		defer server.Close()
+
+		// act
+		response := request(t, server, "/readyz")
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestIsLeading(t *testing.T) {
+	t.Run("ReturnsTrueByDefault", func(t *testing.T) {
+
+		service := getTestService(t)
+
+		// act
+		leading := service.IsLeading()
+
+		assert.True(t, leading)
+	})
+
+	t.Run("ReturnsLeadingStateSetLast", func(t *testing.T) {
+
+		service := getTestService(t)
+		service.SetLeading(false)
+
+		// act
+		leading := service.IsLeading()
+
+		assert.False(t, leading)
+	})
+}
+
+// getTestService returns a service with an interval of a minute that considers the cleaner wedged after 3 minutes
+func getTestService(t *testing.T) *service {
+	s, err := NewService(time.Minute, 3)
+	assert.Nil(t, err)
+
+	return s.(*service)
+}
+
+func getTestServer(service Service) *httptest.Server {
+	mux := http.NewServeMux()
+	service.RegisterHandlers(mux)
+
+	return httptest.NewServer(mux)
+}
+
+func request(t *testing.T, server *httptest.Server, path string) *http.Response {
+	response, err := http.Get(server.URL + path)
+	assert.Nil(t, err)
+	response.Body.Close()
+
+	return response
+}